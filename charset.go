@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// lookupCharset returns the encoding called name, like gb18030 or big5,
+// or nil for UTF-8, which needs no conversion.
+func lookupCharset(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if enc == encoding.Nop || name == "utf-8" || name == "utf8" {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// decoded returns in converted from charset to UTF-8.
+func decoded(in io.Reader, charset string) io.Reader {
+	enc, _ := lookupCharset(charset) // Checked by parseFlags.
+	if enc == nil {
+		return in
+	}
+	return transform.NewReader(in, enc.NewDecoder())
+}
+
+// encoded returns write with its output converted from UTF-8 to charset.
+func encoded(charset string, write func(io.Writer) error) func(io.Writer) error {
+	enc, _ := lookupCharset(charset) // Checked by parseFlags.
+	if enc == nil {
+		return write
+	}
+	return func(w io.Writer) error {
+		tw := transform.NewWriter(w, enc.NewEncoder())
+		if err := write(tw); err != nil {
+			tw.Close()
+			return err
+		}
+		return tw.Close()
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+	"golang.org/x/net/context"
+)
+
+// checkText is what -check translates.
+const checkText = "测试"
+
+// check translates checkText with t, to see that the key and network
+// work, and reports how it went: on w if it did, or on logger with what
+// most likely went wrong if not. It returns the exit code.
+func check(ctx context.Context, t *translate.Translator, w io.Writer, logger *log.Logger) int {
+	skip, cache := t.SkipUntranslatable, t.Cache
+	t.SkipUntranslatable, t.Cache = false, nil // Make sure it's sent.
+	start := time.Now()
+	results, err := t.TranslateAll(ctx, []string{checkText})
+	t.SkipUntranslatable, t.Cache = skip, cache
+	if err == nil && results[0].Err != nil {
+		err = results[0].Err
+	}
+	if le, ok := err.(*translate.LineError); ok {
+		err = le.Err // There's only the one line.
+	}
+	if err != nil {
+		status := 0
+		if len(results) > 0 {
+			status = results[0].Status
+		}
+		logger.Printf("%s: %v", checkFailure(err, status), err)
+		return 1
+	}
+	fmt.Fprintf(w, "OK: %q translated to %q (%s) in %v\n", checkText, results[0].Text, t.Target, time.Since(start).Round(time.Millisecond))
+	return 0
+}
+
+// checkFailure says what an err from the API with status, 0 if there was
+// no response, most likely means.
+func checkFailure(err error, status int) string {
+	switch {
+	case errors.Is(err, translate.ErrQuotaExceeded):
+		return "API quota exhausted"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden ||
+		status == http.StatusBadRequest && strings.Contains(err.Error(), "API key"):
+		return "API key or credentials rejected"
+	case status == 0:
+		return "Couldn't reach the API"
+	default:
+		return "The API failed"
+	}
+}
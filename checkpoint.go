@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+	"golang.org/x/net/context"
+	"golang.org/x/text/unicode/norm"
+)
+
+// How often -checkpoint saves the lines done so far.
+const checkpointEvery = 5 * time.Second
+
+// translateCheckpointed is TranslateAll for -checkpoint: it skips the lines
+// the checkpoint file at path has already translated and saves it every
+// checkpointEvery with the lines done since, so an interrupted run can
+// carry on where it stopped. Lines go through TranslateStream so that each
+// can be recorded as soon as it's done. Running out of quota returns
+// the line that did as a *translate.LineError, and reaching MaxErrors
+// returns translate.ErrTooManyErrors, as TranslateAll does.
+func translateCheckpointed(ctx context.Context, t *translate.Translator, lines []string, path string) ([]translate.Result, error) {
+	results, err := loadCheckpoint(path, lines, t)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(results))
+	for _, r := range results {
+		done[r.Line] = true
+	}
+	var todo []int // Line of each line sent to the stream.
+	for i := range lines {
+		if !done[i] {
+			todo = append(todo, i)
+		}
+	}
+	progress := func() {
+		if t.Progress != nil {
+			t.Progress(len(results), len(lines))
+		}
+	}
+	progress()
+	// So the feeding stops if the stream does first.
+	feed, stop := context.WithCancel(ctx)
+	defer stop()
+	in := make(chan string)
+	out, err := t.TranslateStream(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(in)
+		for _, l := range todo {
+			select {
+			case in <- lines[l]:
+			case <-feed.Done():
+				return
+			}
+		}
+	}()
+	saved := time.Now()
+	// The stream stops itself; this tells why.
+	stopper := translate.NewStopper(false, t.MaxErrors)
+	for r := range out {
+		r.Line = todo[r.Line]
+		results = append(results, r)
+		stopper.Observe(r)
+		progress()
+		if time.Since(saved) >= checkpointEvery {
+			if err := saveCheckpoint(path, t.Target, results); err != nil {
+				return nil, err
+			}
+			saved = time.Now()
+		}
+	}
+	if err := saveCheckpoint(path, t.Target, results); err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Line < results[j].Line })
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	return results, stopper.Err()
+}
+
+// A checkpoint file has one of these for each line translated.
+type checkpointLine struct {
+	Line        int    `json:"line"` // From 1.
+	Target      string `json:"target"`
+	Source      string `json:"source"`
+	Translation string `json:"translation"`
+}
+
+// loadCheckpoint returns the translated lines recorded in the file at path,
+// if it exists, that are still the same in lines, normalized as t does.
+// A file of translations to another language than t's target is an error.
+func loadCheckpoint(path string, lines []string, t *translate.Translator) ([]translate.Result, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var results []translate.Result
+	dec := json.NewDecoder(f)
+	for {
+		var j checkpointLine
+		if err := dec.Decode(&j); err == io.EOF {
+			return results, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if j.Target != t.Target {
+			return nil, fmt.Errorf("%s: has translations to %q, not %q; remove it to start again", path, j.Target, t.Target)
+		}
+		l := j.Line - 1
+		if l < 0 || l >= len(lines) {
+			continue
+		}
+		line := lines[l]
+		if t.Normalize {
+			line = norm.NFC.String(line)
+		}
+		if line == j.Source {
+			results = append(results, translate.Result{Line: l, Source: j.Source, Text: j.Translation})
+		}
+	}
+}
+
+// saveCheckpoint replaces the file at path with the successful results,
+// atomically so an interruption can't leave half a file.
+func saveCheckpoint(path, target string, results []translate.Result) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		if err := enc.Encode(checkpointLine{Line: r.Line + 1, Target: target, Source: r.Source, Translation: r.Text}); err != nil {
+			return err
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+)
+
+// splitColumn splits each of lines into fields at delim and returns
+// field n, from 1, of each, or "" for lines without that many fields,
+// along with the fields.
+func splitColumn(lines []string, n int, delim string) (column []string, rows [][]string) {
+	column = make([]string, len(lines))
+	rows = make([][]string, len(lines))
+	for i, l := range lines {
+		rows[i] = strings.Split(l, delim)
+		if n <= len(rows[i]) {
+			column[i] = rows[i][n-1]
+		}
+	}
+	return column, rows
+}
+
+// joinColumn puts the translation of column n back in each row of results,
+// so its Source and Text are the whole row. Failed rows keep their column.
+func joinColumn(results []translate.Result, rows [][]string, n int, delim string) {
+	for i := range results {
+		r := &results[i]
+		row := rows[r.Line]
+		r.Source = strings.Join(row, delim)
+		if n > len(row) || r.Err != nil {
+			r.Text = r.Source // Nothing to translate.
+			continue
+		}
+		translated := append([]string(nil), row...)
+		translated[n-1] = r.Text
+		r.Text = strings.Join(translated, delim)
+	}
+}
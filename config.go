@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables
+// that set flags, like SIMP2TRAD_CONCURRENCY for -concurrency.
+const envPrefix = "SIMP2TRAD_"
+
+// envName returns the environment variable for the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets the flags of fs from their SIMP2TRAD_ environment
+// variables, except for those set on the command line.
+func applyEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("%s: %v", envName(f.Name), e)
+		}
+	})
+	return err
+}
+
+// applyConfig sets the flags of fs from the JSON object in the file
+// at path, keyed by flag name, except for those set on the command line.
+// A list sets a repeatable flag, like param, once per element.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, v := range config {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		vs, ok := v.([]interface{})
+		if !ok {
+			vs = []interface{}{v}
+		}
+		for _, v := range vs {
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = v
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				s = strconv.FormatBool(v)
+			default:
+				return fmt.Errorf("%s: %q must be a string, number or boolean", path, name)
+			}
+			if err := fs.Set(name, s); err != nil {
+				return fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
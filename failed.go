@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+)
+
+// errNotAttempted is the error of the lines a run stopped before it got to.
+var errNotAttempted = errors.New("not attempted: the run stopped before this line")
+
+// withUnfinished returns results, which are in line order, with a Result
+// failed with errNotAttempted in place of each of lines they haven't got.
+func withUnfinished(results []translate.Result, lines []string) []translate.Result {
+	if len(results) == len(lines) {
+		return results
+	}
+	all := make([]translate.Result, 0, len(lines))
+	next := 0
+	for i, l := range lines {
+		if next < len(results) && results[next].Line == i {
+			all = append(all, results[next])
+			next++
+			continue
+		}
+		all = append(all, translate.Result{Line: i, Source: l, Err: errNotAttempted})
+	}
+	return all
+}
+
+// attempted returns results without the ones withUnfinished added.
+func attempted(results []translate.Result) []translate.Result {
+	var done []translate.Result
+	for _, r := range results {
+		if r.Err != errNotAttempted {
+			done = append(done, r)
+		}
+	}
+	return done
+}
+
+// writeFailed writes the failed results to the file at path
+// as JSON lines that -retry-failed can read back.
+func writeFailed(path string, results []translate.Result) error {
+	var failed []translate.Result
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return writeOutput(path, nil, func(w io.Writer) error {
+		return writeJSONL(w, failed, nil)
+	})
+}
+
+// readFailed reads the lines written by -failed from the file at path,
+// returning their sources and the line, from 0, each came from.
+func readFailed(path string) (sources []string, lines []int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var j jsonLine
+		if err := dec.Decode(&j); err == io.EOF {
+			return sources, lines, nil
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if j.Line < 1 {
+			return nil, nil, fmt.Errorf("%s: bad line number %d", path, j.Line)
+		}
+		sources = append(sources, j.Source)
+		lines = append(lines, j.Line-1)
+	}
+}
+
+// mergeText puts the successful results in place of their lines
+// in the text output at path, from an earlier run. It is an error
+// for the output to have no line for any of the results.
+func mergeText(path string, results []translate.Result, o *options) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	end := "\n"
+	if o.null {
+		end = "\x00"
+	}
+	unterminated := len(b) > 0 && !bytes.HasSuffix(b, []byte(end))
+	var out []string
+	scanner := newScanner(bytes.NewReader(b))
+	if o.null {
+		scanner.Split(scanNUL)
+	}
+	for scanner.Scan() {
+		out = append(out, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Line >= len(out) {
+			return fmt.Errorf("%s has no line %d; it has %d", path, r.Line+1, len(out))
+		}
+		if r.Err != nil {
+			continue
+		}
+		line := r.Text
+		if o.showSource {
+			line = joinFields([]string{r.Source, r.Text}, o.delimiter)
+		}
+		out[r.Line] = line
+	}
+	s := strings.Join(out, end)
+	if !unterminated && len(out) > 0 {
+		s += end
+	}
+	return os.WriteFile(path, []byte(s), 0666)
+}
+
+// errorReport is what -error-report writes.
+type errorReport struct {
+	Lines  int               `json:"lines"` // Translated or not.
+	Failed int               `json:"failed"`
+	Errors []errorReportLine `json:"errors"`
+}
+
+type errorReportLine struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line"` // From 1, in File if it's set.
+	Source   string `json:"source"`
+	Status   int    `json:"status,omitempty"` // Of the last attempt; absent if there was no response.
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+}
+
+// writeErrorReport writes a JSON report of the failed results
+// to the file at path, out of lines in all.
+func writeErrorReport(path string, results []translate.Result, lines int, o *options) error {
+	report := errorReport{Lines: lines, Errors: []errorReportLine{}}
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		file, n := position(r.Line, o)
+		report.Errors = append(report.Errors, errorReportLine{File: file, Line: n + 1, Source: r.Source,
+			Status: r.Status, Error: r.Err.Error(), Attempts: r.Attempts})
+	}
+	report.Failed = len(report.Errors)
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0666)
+}
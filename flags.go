@@ -0,0 +1,465 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+)
+
+// paramsFlag collects repeated -param key=value flags.
+type paramsFlag url.Values
+
+func (p paramsFlag) String() string { return url.Values(p).Encode() }
+
+func (p paramsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("want key=value")
+	}
+	for _, r := range translate.ReservedParams {
+		if k == r {
+			return fmt.Errorf("%s has its own flag", k)
+		}
+	}
+	url.Values(p).Add(k, v)
+	return nil
+}
+
+// headerFlag collects repeated -header key=value flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var kvs []string
+	for k, vs := range h {
+		for _, v := range vs {
+			kvs = append(kvs, k+"="+v)
+		}
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+func (h headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("want key=value")
+	}
+	http.Header(h).Add(k, v)
+	return nil
+}
+
+// autoConcurrency is the most workers -concurrency=auto uses.
+const autoConcurrency = 100
+
+// concurrencyFlag is -concurrency: a number of workers, or auto.
+type concurrencyFlag struct {
+	n    *int
+	auto *bool
+}
+
+func (c concurrencyFlag) String() string {
+	if c.auto != nil && *c.auto {
+		return "auto"
+	}
+	if c.n == nil {
+		return ""
+	}
+	return strconv.Itoa(*c.n)
+}
+
+func (c concurrencyFlag) Set(s string) error {
+	if *c.auto = s == "auto"; *c.auto {
+		*c.n = autoConcurrency
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("want a number or auto")
+	}
+	*c.n = n
+	return nil
+}
+
+// regexpFlag is a flag for a regular expression.
+type regexpFlag struct{ re **regexp.Regexp }
+
+func (r regexpFlag) String() string {
+	if r.re == nil || *r.re == nil {
+		return ""
+	}
+	return (*r.re).String()
+}
+
+func (r regexpFlag) Set(s string) (err error) {
+	*r.re, err = regexp.Compile(s)
+	return err
+}
+
+// unescape interprets the Go escapes, like \t and \x00, in s.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	return strconv.Unquote(`"` + strings.ReplaceAll(s, `"`, `\"`) + `"`)
+}
+
+// csvDelimiter reports whether d can separate CSV fields.
+func csvDelimiter(d string) bool {
+	r, n := utf8.DecodeRuneInString(d)
+	return n == len(d) && r != utf8.RuneError && r != '"' && r != '\r' && r != '\n'
+}
+
+// variants are the shorthands -variant takes for regional -targets.
+var variants = map[string]string{
+	"hk": "zh-HK",
+	"tw": "zh-TW",
+}
+
+// options holds the command-line flags.
+type options struct {
+	source, target string
+	retries        int
+	batch          int
+	concurrency    int
+	auto           bool
+	rate           float64
+	burst          int
+	in, out        string
+	timeout        time.Duration
+	format         string
+	key, keyFile   string
+	dryRun         bool
+	countOnly      bool
+	dedup          bool
+	cache          string
+	skip           bool
+	progress       bool
+	maxLines       int
+	verbose        bool
+	noUnescape     bool
+	showSource     bool
+	delimiter      string
+	textFormat     string
+	detect         bool
+	minRate        float64
+	maxChars       int
+	maxBytes       int
+	null           bool
+	cpuProfile     string
+	memProfile     string
+	apiVersion     int
+	credentials    string
+	project        string
+	glossary       string
+	unordered      bool
+	stream         bool
+	stats          bool
+	baseURL        string
+	failed         string
+	retryFailed    string
+	normalize      bool
+	params         url.Values
+	header         http.Header
+	sample         float64
+	sampleN        int
+	seed           int64
+	verify         bool
+	gzip           bool
+	failFast       bool
+	inputCharset   string
+	outputCharset  string
+	maxInFlight    int
+	config         string
+	column         int
+	mock           bool
+	requestTimeout time.Duration
+	quiet          bool
+	checkpoint     string
+	trim           bool
+	minInterval    time.Duration
+	provider       string
+	lineNumbers    bool
+	lineNumberSep  string
+	delimiterSet   bool // -delimiter was given, so csv and tsv use it too.
+	variant        string
+	trace          bool
+	showFile       bool
+	commentPrefix  string
+	errorReport    string
+	detectInline   bool
+	warnMixed      bool
+	continuation   string
+	resplit        bool
+	check          bool
+	protect        *regexp.Regexp
+	maxErrors      int
+	changedOnly    bool
+	whole          bool
+	dropComments   bool
+	fileLines      bool
+
+	// Not flags: with more than one input file, all of them, -in first,
+	// and the line each starts at, from 0. It's set as they're read.
+	files     []string
+	fileStart []int
+
+	// Not a flag: set when the input doesn't end with a newline,
+	// so that the text output doesn't either.
+	noFinalNewline bool
+	// Not a flag: the back translations for -verify, by line.
+	back map[int]string
+}
+
+// parseFlags parses args, printing usage and errors to stderr.
+func parseFlags(args []string, stderr io.Writer) (*options, error) {
+	var o options
+	fs := flag.NewFlagSet("simp-to-trad-goog-api", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.source, "source", translate.DefaultSource, "language to translate from")
+	fs.StringVar(&o.target, "target", translate.DefaultTarget, "language to translate to")
+	fs.IntVar(&o.retries, "retries", translate.DefaultRetries, "times to retry a request on 429 and 5xx responses")
+	fs.IntVar(&o.batch, "batch", translate.DefaultBatchSize, "maximum lines per request")
+	o.concurrency = translate.DefaultConcurrency
+	fs.Var(concurrencyFlag{&o.concurrency, &o.auto}, "concurrency", "make `n` concurrent requests, or auto to make as many as keep up with -rate, up to "+strconv.Itoa(autoConcurrency))
+	fs.Float64Var(&o.rate, "rate", translate.DefaultRate, "maximum requests per second")
+	fs.IntVar(&o.burst, "burst", 0, "requests allowed at once above -rate (default -concurrency)")
+	fs.StringVar(&o.in, "in", "", "read lines from this file instead of stdin")
+	fs.StringVar(&o.out, "out", "", "write translations to this file instead of stdout")
+	fs.DurationVar(&o.timeout, "timeout", translate.DefaultTimeout, "give up on a request after this long")
+	fs.StringVar(&o.format, "format", "text", "output format: text, jsonl, csv, tsv or table")
+	fs.StringVar(&o.key, "key", "", "API key (overrides -key-file and "+apiKeyEnvVar+")")
+	fs.StringVar(&o.keyFile, "key-file", "", "read the API key from this file (overrides "+apiKeyEnvVar+")")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "print the requests that would be made and exit")
+	fs.BoolVar(&o.countOnly, "count-only", false, "like -dry-run, but print only how many requests and characters there would be")
+	fs.BoolVar(&o.dedup, "dedup", false, "translate each distinct line only once")
+	fs.StringVar(&o.cache, "cache", "", "reuse and save translations in this file across runs")
+	fs.BoolVar(&o.skip, "skip-untranslatable", false, "pass lines without simplified characters through unchanged")
+	fs.BoolVar(&o.progress, "progress", false, "show a count of translated lines on stderr")
+	fs.IntVar(&o.maxLines, "max-lines", 0, "translate only the first `n` lines (0 for all)")
+	fs.BoolVar(&o.verbose, "v", false, "log every request on stderr")
+	fs.BoolVar(&o.verbose, "verbose", false, "same as -v")
+	fs.BoolVar(&o.noUnescape, "no-unescape", false, "keep HTML entities like &#39; in translations")
+	fs.BoolVar(&o.showSource, "show-source", false, "print each source line before its translation")
+	fs.StringVar(&o.delimiter, "delimiter", "\t", "separates the columns of -show-source, -verify, -translate-column and -line-numbers, and of csv and tsv if given; takes escapes like \\t")
+	fs.StringVar(&o.textFormat, "text-format", translate.DefaultFormat, "how the API reads input: text or html (keeps tags)")
+	fs.BoolVar(&o.detect, "detect", false, "detect each line's language instead of using -source")
+	fs.BoolVar(&o.detectInline, "autodetect-inline", false, "like -detect, but have the API detect each line's language as it translates, without the extra requests")
+	fs.Float64Var(&o.minRate, "min-rate", 0, "on repeated 429s, slow down as far as this many requests per second (0 to keep -rate)")
+	fs.IntVar(&o.maxChars, "max-chars", translate.DefaultMaxChars, "split longer lines into sentences and translate them in pieces (0 never splits)")
+	fs.IntVar(&o.maxBytes, "max-bytes", translate.DefaultMaxBytes, "send at most this many bytes of text per request, splitting longer lines like -max-chars, or failing them if that's 0 (0 for no limit)")
+	fs.BoolVar(&o.null, "0", false, "input and text output records end with NUL instead of newline, as with xargs -0")
+	fs.IntVar(&o.apiVersion, "api-version", 2, "Translate API version: 2, with an API key, or 3, with a service account")
+	fs.StringVar(&o.credentials, "credentials", "", "service-account JSON key file for -api-version=3 (default $GOOGLE_APPLICATION_CREDENTIALS)")
+	fs.StringVar(&o.project, "project", "", "Google Cloud project for -api-version=3 (default the service account's)")
+	fs.StringVar(&o.glossary, "glossary", "", "file of source<TAB>translation terms to substitute before translating, longest first")
+	fs.BoolVar(&o.unordered, "unordered", false, "write each translation as soon as it's done, in no particular order, instead of holding them all until the end")
+	fs.BoolVar(&o.stream, "stream", false, "like -unordered, but in order: write each translation once it and those before it are done, for input that keeps coming, like tail -f")
+	fs.BoolVar(&o.stats, "stats", false, "print request counts, bytes and timing to stderr at the end")
+	fs.StringVar(&o.baseURL, "base-url", "", "send requests here instead of Google's default endpoint, e.g. a regional one or a test server")
+	fs.StringVar(&o.failed, "failed", "", "write the lines that failed to this file, for -retry-failed")
+	fs.StringVar(&o.retryFailed, "retry-failed", "", "translate only the lines in this file from -failed; with -format=text the translations replace those lines of -out")
+	fs.BoolVar(&o.normalize, "normalize", false, "put lines in Unicode NFC before translating, so equivalent lines are cached and deduplicated together")
+	fs.Float64Var(&o.sample, "sample", 0, "translate only this fraction of the lines, picked at random, e.g. 0.05")
+	fs.IntVar(&o.sampleN, "sample-n", 0, "translate only this many lines, picked at random")
+	fs.Int64Var(&o.seed, "seed", 1, "random seed for -sample and -sample-n")
+	fs.BoolVar(&o.verify, "verify", false, "translate each line back to -source and say whether it round-trips exactly; doubles the requests")
+	fs.BoolVar(&o.gzip, "gzip", false, "gzip the bodies of long requests, which are POSTed (responses are always gzipped)")
+	fs.BoolVar(&o.failFast, "fail-fast", false, "stop at the first line that fails and write nothing")
+	fs.StringVar(&o.inputCharset, "input-charset", "", "read input in this charset, like gb18030 or big5, instead of UTF-8")
+	fs.StringVar(&o.outputCharset, "output-charset", "", "write output in this charset instead of UTF-8")
+	fs.IntVar(&o.maxInFlight, "max-inflight", 0, "requests outstanding at once, whatever the -concurrency (0 for -concurrency)")
+	fs.StringVar(&o.config, "config", "", "read defaults for these flags from this JSON file, e.g. {\"target\": \"ja\", \"rate\": 10}")
+	fs.IntVar(&o.column, "translate-column", 0, "translate only field `n`, from 1, of each line split at -delimiter, keeping the others (0 for the whole line)")
+	fs.BoolVar(&o.mock, "mock", false, "don't call the API: \"translate\" each line by prefixing it with [-target], for trying things out offline")
+	fs.DurationVar(&o.requestTimeout, "request-timeout", 0, "fail a batch of lines if translating it, retries and all, takes longer than this (0 for no limit; -timeout limits each attempt)")
+	fs.BoolVar(&o.quiet, "quiet", false, "print nothing on stderr but errors that stop the run; failed lines still make the exit status 1")
+	fs.StringVar(&o.checkpoint, "checkpoint", "", "record finished lines in this file as they're done, and skip the ones already in it, to resume an interrupted run")
+	fs.BoolVar(&o.trim, "trim", false, "send lines without their leading and trailing space, and put it back around the translations")
+	fs.DurationVar(&o.minInterval, "min-interval", 0, "keep each worker's requests at least this far apart, as well as -rate")
+	fs.StringVar(&o.provider, "provider", "google", "translation service to use; only google for now")
+	fs.BoolVar(&o.lineNumbers, "line-numbers", false, "start each line of text output with its input line number, from 1")
+	fs.StringVar(&o.lineNumberSep, "line-number-separator", "", "what goes between the number and the line with -line-numbers (default -delimiter)")
+	fs.StringVar(&o.variant, "variant", "", "regional Traditional Chinese to translate to: hk for zh-HK or tw for zh-TW, instead of -target")
+	fs.BoolVar(&o.trace, "trace", false, "log each request's DNS, connect, TLS and first-byte timings on stderr")
+	fs.BoolVar(&o.showFile, "show-file", false, "add the input file each line came from to jsonl, csv and tsv output")
+	fs.BoolVar(&o.fileLines, "file-line-numbers", false, "with several input files, number lines from 1 in each file rather than across them all")
+	fs.StringVar(&o.commentPrefix, "comment-prefix", "", "pass lines starting with this, like #, through untranslated as comments")
+	fs.BoolVar(&o.dropComments, "drop-comments", false, "leave -comment-prefix lines out of the output instead; line numbers still count them")
+	fs.StringVar(&o.errorReport, "error-report", "", "write a JSON report of every line that failed, with its status, error and attempts, to this file")
+	fs.BoolVar(&o.warnMixed, "warn-mixed", false, "warn on stderr about input lines with both simplified and traditional characters")
+	fs.StringVar(&o.continuation, "continuation", "", "join a line ending with this `marker`, like \\, to the next, without the marker, and translate them as one")
+	fs.BoolVar(&o.resplit, "resplit", false, "with -continuation, keep the joined lines apart and split the text output back into them, each but the last ending with the marker")
+	fs.BoolVar(&o.check, "check", false, "translate one word to check the API key and network work, report how it went and exit, without reading input")
+	fs.Var(regexpFlag{&o.protect}, "protect-pattern", "keep what this `regexp` matches, like the placeholders in {name}|%s, out of the translation and exactly as it was")
+	fs.IntVar(&o.maxErrors, "max-errors", 0, "stop once this many lines have failed, writing what was translated, when that many suggest something's wrong (0 for no limit)")
+	fs.BoolVar(&o.changedOnly, "changed-only", false, "write only the lines whose translation differs from the source, with their line numbers")
+	fs.BoolVar(&o.whole, "whole", false, "translate all the input as one document, keeping its newlines, so the API has the context of the sentences around each; -max-chars still splits it")
+	o.params = url.Values{}
+	fs.Var(paramsFlag(o.params), "param", "extra `key=value` parameter for every request, e.g. model=nmt; may be repeated")
+	o.header = http.Header{}
+	fs.Var(headerFlag(o.header), "header", "extra `key=value` header for every request, e.g. User-Agent=mytool/1.0 to replace "+translate.DefaultUserAgent+"; may be repeated")
+	fs.StringVar(&o.cpuProfile, "cpuprofile", "", "write a pprof CPU profile to this file")
+	fs.StringVar(&o.memProfile, "memprofile", "", "write a pprof heap profile to this file on exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	if o.config != "" {
+		if err := applyConfig(fs, o.config); err != nil {
+			return nil, err
+		}
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if o.variant != "" {
+		target, ok := variants[strings.ToLower(o.variant)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown -variant %q; want hk or tw", o.variant)
+		}
+		if set["target"] && !strings.EqualFold(o.target, target) {
+			return nil, fmt.Errorf("-variant=%s means -target=%s, not %s", o.variant, target, o.target)
+		}
+		o.target = target
+	}
+	// Both take escapes like \t, which are awkward to type otherwise.
+	for _, s := range []*string{&o.delimiter, &o.lineNumberSep} {
+		u, err := unescape(*s)
+		if err != nil {
+			return nil, fmt.Errorf("bad escape in %q", *s)
+		}
+		*s = u
+	}
+	if o.changedOnly {
+		// Otherwise there's no telling which lines they are.
+		o.lineNumbers = true
+	}
+	if !set["line-number-separator"] {
+		o.lineNumberSep = o.delimiter
+	}
+	o.delimiterSet = set["delimiter"]
+	// Files after the flags are read after -in, as one input.
+	if args := fs.Args(); len(args) > 0 {
+		if o.in != "" {
+			o.files = append(o.files, o.in)
+		}
+		if o.files = append(o.files, args...); len(o.files) == 1 {
+			o.in, o.files = o.files[0], nil
+		}
+	}
+	if o.countOnly {
+		o.dryRun = true
+	}
+	// -stream is -unordered putting the lines back in order as it goes.
+	if o.stream {
+		o.unordered = true
+	}
+	switch {
+	case o.source == "" || o.target == "":
+		return nil, errors.New("-source and -target must not be empty")
+	case o.concurrency <= 0 || o.rate <= 0:
+		return nil, errors.New("-concurrency and -rate must be positive")
+	case o.minRate < 0 || o.minRate > o.rate:
+		return nil, errors.New("-min-rate must be between 0 and -rate")
+	case o.burst < 0 || o.maxInFlight < 0:
+		return nil, errors.New("-burst and -max-inflight must not be negative")
+	case o.maxLines < 0 || o.maxChars < 0:
+		return nil, errors.New("-max-lines and -max-chars must not be negative")
+	case o.maxBytes < 0 || o.maxBytes > 0 && o.maxBytes < utf8.UTFMax:
+		return nil, fmt.Errorf("-max-bytes must be 0 or at least %d", utf8.UTFMax)
+	case o.textFormat != "text" && o.textFormat != "html":
+		return nil, errors.New("-text-format must be text or html")
+	case o.delimiter == "":
+		return nil, errors.New("-delimiter must not be empty")
+	case o.delimiterSet && (o.format == "csv" || o.format == "tsv") && !csvDelimiter(o.delimiter):
+		return nil, fmt.Errorf("-format=%s needs -delimiter to be one character other than a quote or newline", o.format)
+	case o.apiVersion != 2 && o.apiVersion != 3:
+		return nil, errors.New("-api-version must be 2 or 3")
+	case o.apiVersion == 3 && len(o.params) > 0:
+		return nil, errors.New("-param needs -api-version=2")
+	case o.apiVersion == 3 && o.detect:
+		return nil, errors.New("-detect needs -api-version=2")
+	case o.detect && o.detectInline:
+		return nil, errors.New("use -detect or -autodetect-inline, not both")
+	case o.sample < 0 || o.sample > 1 || o.sampleN < 0:
+		return nil, errors.New("-sample must be between 0 and 1, and -sample-n must not be negative")
+	case o.sample != 0 && o.sampleN != 0:
+		return nil, errors.New("use -sample or -sample-n, not both")
+	case (o.sample != 0 || o.sampleN != 0) && (o.unordered || o.retryFailed != ""):
+		return nil, errors.New("-sample and -sample-n can't be used with -unordered or -retry-failed")
+	case o.checkpoint != "" && (o.unordered || o.dedup || o.failFast):
+		return nil, errors.New("-checkpoint can't be used with -unordered, -dedup or -fail-fast")
+	case o.quiet && (o.verbose || o.progress || o.stats || o.trace):
+		return nil, errors.New("-quiet can't be used with -v, -progress, -stats or -trace")
+	case o.mock && o.apiVersion != 2:
+		return nil, errors.New("-mock needs -api-version=2")
+	case o.mock && (o.cache != "" || o.checkpoint != ""):
+		// A later real run would take the mock output for translations.
+		return nil, errors.New("-mock can't be used with -cache or -checkpoint")
+	case o.column < 0:
+		return nil, errors.New("-translate-column must not be negative")
+	case o.column > 0 && (o.unordered || o.verify):
+		return nil, errors.New("-translate-column can't be used with -unordered or -verify")
+	case o.unordered && o.failFast:
+		return nil, errors.New("-unordered can't be used with -fail-fast")
+	case o.maxErrors < 0:
+		return nil, errors.New("-max-errors must not be negative")
+	case o.failFast && o.maxErrors != 0:
+		return nil, errors.New("use -fail-fast or -max-errors, not both")
+	case o.unordered && o.verify:
+		return nil, errors.New("-unordered can't be used with -verify")
+	case o.unordered && (o.retryFailed != "" || o.failed != "" || o.errorReport != ""):
+		return nil, errors.New("-unordered can't be used with -failed, -retry-failed or -error-report")
+	case o.check && o.dryRun:
+		return nil, errors.New("-check can't be used with -dry-run or -count-only")
+	case o.whole && (o.unordered || o.retryFailed != "" || o.sample != 0 || o.sampleN != 0 || o.column > 0 || o.null || o.resplit):
+		// -resplit would take the newlines between lines for its own.
+		return nil, errors.New("-whole can't be used with -unordered, -retry-failed, -sample, -sample-n, -translate-column, -0 or -resplit")
+	case o.changedOnly && o.retryFailed != "":
+		return nil, errors.New("-changed-only can't be used with -retry-failed")
+	case o.resplit && o.continuation == "":
+		return nil, errors.New("-resplit needs -continuation")
+	case o.dropComments && o.commentPrefix == "":
+		return nil, errors.New("-drop-comments needs -comment-prefix")
+	case o.files != nil && (o.unordered || o.retryFailed != ""):
+		return nil, errors.New("-unordered and -retry-failed take only one input file")
+	case o.showFile && o.in == "" && o.files == nil:
+		return nil, errors.New("-show-file needs -in or input files")
+	case o.unordered && o.dedup:
+		return nil, errors.New("-unordered can't be used with -dedup")
+	case o.unordered && o.format != "text" && o.format != "jsonl":
+		return nil, errors.New("-unordered needs -format=text or jsonl")
+	}
+	if o.baseURL != "" {
+		if u, err := url.Parse(o.baseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("-base-url %q isn't an absolute URL", o.baseURL)
+		}
+	}
+	for _, cs := range []string{o.inputCharset, o.outputCharset} {
+		if _, err := lookupCharset(cs); err != nil {
+			return nil, fmt.Errorf("Unknown charset %q", cs)
+		}
+	}
+	if o.outputCharset != "" && o.retryFailed != "" {
+		return nil, errors.New("-output-charset can't be used with -retry-failed")
+	}
+	if _, ok := providers[o.provider]; !ok {
+		return nil, fmt.Errorf("Unknown -provider %q", o.provider)
+	}
+	if _, ok := writers[o.format]; !ok {
+		return nil, fmt.Errorf("Unknown -format %q", o.format)
+	}
+	return &o, nil
+}
@@ -0,0 +1,16 @@
+module github.com/purohit/simp-to-trad-goog-api
+
+go 1.26.0
+
+require (
+	golang.org/x/net v0.59.0
+	golang.org/x/oauth2 v0.37.0
+	golang.org/x/term v0.46.0
+	golang.org/x/text v0.42.0
+	golang.org/x/time v0.16.0
+)
+
+require (
+	cloud.google.com/go/compute/metadata v0.10.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+)
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+	"golang.org/x/term"
+)
+
+// maxLine is the longest line read. A longer one is an error
+// rather than being cut short; -max-chars splits what's sent.
+const maxLine = 64 << 20
+
+// newScanner returns a scanner of r that reads lines up to maxLine long.
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLine)
+	return scanner
+}
+
+// readLines returns the lines of in, or its NUL-separated records with -0,
+// stopping after -max-lines of them if that's set.
+// unterminated reports whether in ended without a final newline (or NUL).
+func readLines(in io.Reader, o *options) (lines []string, unterminated bool, err error) {
+	unterminated, err = scanLines(in, o, func(l string) {
+		lines = append(lines, l)
+	})
+	return lines, unterminated, err
+}
+
+// scanLines is readLines calling each with every line as it's read.
+// With -continuation, a line is all those up to one not ending with
+// the marker, joined without it, and with a newline between them
+// for -resplit.
+func scanLines(in io.Reader, o *options, each func(string)) (unterminated bool, err error) {
+	lb := &lastByte{r: in}
+	scanner := newScanner(lb)
+	end := byte('\n')
+	if o.null {
+		scanner.Split(scanNUL)
+		end = 0
+	}
+	n := 0
+	var continued []string
+	for (o.maxLines <= 0 || n < o.maxLines) && scanner.Scan() {
+		l := scanner.Text()
+		if n == 0 && continued == nil {
+			// Windows tools like to start UTF-8 files with a BOM.
+			l = strings.TrimPrefix(l, "\uFEFF")
+		}
+		if o.continuation != "" && strings.HasSuffix(l, o.continuation) {
+			continued = append(continued, strings.TrimSuffix(l, o.continuation))
+			continue
+		}
+		if continued != nil {
+			l = joinContinued(append(continued, l), o)
+			continued = nil
+		}
+		each(l)
+		n++
+	}
+	if continued != nil && scanner.Err() == nil {
+		// The last line ended with the marker.
+		each(joinContinued(continued, o))
+		n++
+	}
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		return false, fmt.Errorf("line %d is longer than %d MB", n+1, maxLine>>20)
+	} else if err != nil {
+		return false, err
+	}
+	// Only meaningful if we read everything.
+	truncated := o.maxLines > 0 && n == o.maxLines
+	return !truncated && lb.n > 0 && lb.last != end, nil
+}
+
+// joinContinued joins the lines of a -continuation line.
+func joinContinued(lines []string, o *options) string {
+	if o.resplit {
+		return strings.Join(lines, "\n")
+	}
+	return strings.Join(lines, "")
+}
+
+// resplit gives back the lines s was joined from with -resplit.
+func resplit(s string, o *options) string {
+	if !o.resplit {
+		return s
+	}
+	return strings.ReplaceAll(s, "\n", o.continuation+"\n")
+}
+
+// readFiles is readLines for each of o.files in turn, as one input.
+// -max-lines counts across all of them.
+func readFiles(o *options) (lines []string, unterminated bool, err error) {
+	for _, path := range o.files {
+		if o.maxLines > 0 && len(lines) >= o.maxLines {
+			break
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, false, err
+		}
+		fo := *o
+		if o.maxLines > 0 {
+			fo.maxLines = o.maxLines - len(lines)
+		}
+		o.fileStart = append(o.fileStart, len(lines))
+		ls, u, err := readLines(decoded(f, o.inputCharset), &fo)
+		f.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %v", path, err)
+		}
+		lines, unterminated = append(lines, ls...), u
+	}
+	return lines, unterminated, nil
+}
+
+// position returns the input file line (from 0) came from,
+// or "" for stdin, and its number in that file.
+func position(line int, o *options) (file string, n int) {
+	if o.fileStart == nil {
+		return o.in, line
+	}
+	i := sort.Search(len(o.fileStart), func(i int) bool { return o.fileStart[i] > line }) - 1
+	return o.files[i], line - o.fileStart[i]
+}
+
+// lineNumber returns the number, from 0, to show for line:
+// its number in its file with -file-line-numbers, or else overall.
+func lineNumber(line int, o *options) int {
+	if o.fileLines {
+		_, line = position(line, o)
+	}
+	return line
+}
+
+// warnMixed warns on w if line, from 0, has both
+// simplified and traditional characters.
+func warnMixed(w io.Writer, line int, text string, o *options) {
+	if s, t := translate.Scripts(text); !s || !t {
+		return
+	}
+	if file, n := position(line, o); o.files != nil {
+		fmt.Fprintf(w, "%s:%d: warning: mixed simplified and traditional characters\n", file, n+1)
+	} else {
+		fmt.Fprintf(w, "line %d: warning: mixed simplified and traditional characters\n", line+1)
+	}
+}
+
+// lastByte remembers the last byte read through it.
+type lastByte struct {
+	r    io.Reader
+	n    int64
+	last byte
+}
+
+func (l *lastByte) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if n > 0 {
+		l.n += int64(n)
+		l.last = p[n-1]
+	}
+	return n, err
+}
+
+// scanNUL is a bufio.SplitFunc like bufio.ScanLines
+// for records that end with a NUL byte.
+func scanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		// The last record needn't be terminated.
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// isTerminal reports whether r is a terminal rather than a pipe or file.
+// Tests replace it.
+var isTerminal = func(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// readGlossary reads the file at path of source<TAB>translation terms,
+// one per line. Blank lines are ignored.
+func readGlossary(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	glossary := make(map[string]string)
+	scanner := newScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		term, translation, ok := strings.Cut(line, "\t")
+		if !ok || term == "" {
+			return nil, fmt.Errorf("%s:%d: want source<TAB>translation", path, n)
+		}
+		glossary[term] = translation
+	}
+	return glossary, scanner.Err()
+}
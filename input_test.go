@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTerminalHint(t *testing.T) {
+	defer func(f func(io.Reader) bool) { isTerminal = f }(isTerminal)
+	const hint = "Reading lines to translate from the terminal"
+	tests := []struct {
+		name     string
+		terminal bool
+		args     []string
+		hint     bool
+	}{
+		{"terminal", true, nil, true},
+		{"pipe", false, nil, false},
+		{"terminal with -in", true, []string{"-in", "/dev/null"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isTerminal = func(io.Reader) bool { return tt.terminal }
+			_, stderr, code := runCLI(t, "一\n", append([]string{"-mock"}, tt.args...)...)
+			if code != 0 {
+				t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+			}
+			if got := strings.Contains(stderr, hint); got != tt.hint {
+				t.Errorf("hint shown: %v, want %v; stderr:\n%s", got, tt.hint, stderr)
+			}
+		})
+	}
+}
+
+func TestReadLongLines(t *testing.T) {
+	long := strings.Repeat("长", 100<<10/3) // Over bufio.Scanner's 64 KB default.
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{name: "over 64 KB", in: "一\n" + long + "\n二\n", want: []string{"一", long, "二"}},
+		{name: "over maxLine", in: "一\n二\n" + strings.Repeat("a", maxLine+1) + "\n", wantErr: fmt.Sprintf("line 3 is longer than %d MB", maxLine>>20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, _, err := readLines(strings.NewReader(tt.in), &options{})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d", len(lines), len(tt.want))
+			}
+			for i := range lines {
+				if lines[i] != tt.want[i] {
+					t.Errorf("line %d is %d bytes, want %d", i+1, len(lines[i]), len(tt.want[i]))
+				}
+			}
+		})
+	}
+}
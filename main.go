@@ -2,146 +2,461 @@
 // one per line, from simplified to traditional
 // Chinese using the Google Translate API.
 // Outputs the strings to stdout at the end, in order.
-// Your API key needs to be set as an environment var.
+// Your API key needs to be set as an environment var,
+// or given with -key or -key-file.
+//
+// With -api-version=3 it uses the v3 API instead,
+// authenticating with a service-account key given with -credentials.
+//
+// Files named after the flags are read instead, one after another,
+// and -show-file says which each line came from.
+//
+// The language pair can be changed with -source and -target,
+// e.g. -source=en -target=ja reuses the whole worker pool
+// for English to Japanese.
+//
+// Any flag can also be set in the environment, as SIMP2TRAD_ and its
+// name in capitals with _ for -, like SIMP2TRAD_CONCURRENCY=20 or
+// SIMP2TRAD_TARGET=zh-HK. Flags on the command line win, and both
+// win over -config.
+//
+// Given the same input, flags and API responses, the output and the
+// errors reported are the same from run to run, whatever order the
+// requests finish in. Only -unordered output and timings vary.
+//
+// Only translations go to stdout; errors, warnings, progress and
+// logs go to stderr, where -quiet keeps all but fatal errors out.
+//
+// The translation itself lives in the translate package
+// so other programs can use it.
 
 package main
 
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"sort"
-	"sync"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
+	"github.com/purohit/simp-to-trad-goog-api/translate"
 	"golang.org/x/net/context"
-	"golang.org/x/time/rate"
 )
 
 const (
-	apiKeyEnvVar = "GOOGLE_API_KEY"
-	baseURL      = "https://www.googleapis.com/language/translate/v2"
-
-	from = "zh-CN"
-	to   = "zh-TW"
-
-	maxRequestsPerSec = 100 // 100/s is the maximum rate limit, specified by Google
-	jobs              = 20  // 20 concurrent network requests.
+	apiKeyEnvVar      = "GOOGLE_API_KEY"
+	credentialsEnvVar = "GOOGLE_APPLICATION_CREDENTIALS"
 )
 
-// Data comes from this nested JSON structure:
-//{"data": {"translations": [{"translatedText": "你覺得緊張嗎？"]}}}
-
-type respJSON struct {
-	Data tJSON `json:"data"`
+// lookupKey returns the API key from, in order of precedence,
+// the -key flag, the file named by -key-file, or the environment.
+func lookupKey(key, keyFile string) (string, error) {
+	if key != "" {
+		return key, nil
+	}
+	if keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", err
+		}
+		if key = strings.TrimSpace(string(b)); key == "" {
+			return "", fmt.Errorf("%s is empty", keyFile)
+		}
+		return key, nil
+	}
+	if key = os.Getenv(apiKeyEnvVar); key != "" {
+		return key, nil
+	}
+	return "", fmt.Errorf("No API key supplied; use -key, -key-file or set %s", apiKeyEnvVar)
 }
 
-type tJSON struct {
-	Translations []ttJSON `json:"translations"`
+// providers are the -provider names, with what to set
+// Translator.Provider to.
+var providers = map[string]func(*translate.Translator) translate.Provider{
+	"google": func(t *translate.Translator) translate.Provider { return translate.Google{T: t} },
 }
 
-type ttJSON struct {
-	Text string `json:"translatedText"`
+// serviceAccount returns a client that authenticates as the service
+// account in the key file at path, or in $GOOGLE_APPLICATION_CREDENTIALS,
+// and the account's project.
+func serviceAccount(path string, o *options) (*http.Client, string, error) {
+	if path == "" {
+		path = os.Getenv(credentialsEnvVar)
+	}
+	if path == "" {
+		return nil, "", fmt.Errorf("No service account supplied; use -credentials or set %s", credentialsEnvVar)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return translate.NewServiceAccountClient(context.Background(), b, o.concurrency, o.timeout)
 }
 
-type sourceText struct {
-	line int
-	text string
+// dryRun prints the requests t would make for lines, unless countOnly,
+// and how much quota they would use.
+func dryRun(w io.Writer, t *translate.Translator, lines []string, countOnly bool) error {
+	reqs, err := t.Plan(lines)
+	if err != nil {
+		return err
+	}
+	chars := 0
+	for _, req := range reqs {
+		if !countOnly {
+			fmt.Fprintln(w, req.Method, req.URL)
+		}
+		q := req.URL.Query()
+		if req.Body != nil {
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+			if !countOnly {
+				fmt.Fprintf(w, "\t%s\n", body)
+			}
+			if req.Header.Get("Content-Type") == "application/json" {
+				// A v3 request.
+				var j struct{ Contents []string }
+				if err := json.Unmarshal(body, &j); err != nil {
+					return err
+				}
+				q = url.Values{"q": j.Contents}
+			} else if q, err = url.ParseQuery(string(body)); err != nil {
+				return err
+			}
+		}
+		for _, text := range q["q"] {
+			chars += utf8.RuneCountInString(text)
+		}
+	}
+	_, err = fmt.Fprintf(w, "%d requests, %d characters\n", len(reqs), chars)
+	return err
 }
 
-type result struct {
-	line int
-	text string
-	err  error
+// progress returns a Translator.Progress func that keeps
+// a count of finished lines and an ETA on one line of w.
+// A total of -1 means it isn't known yet, so there's just the count.
+func progress(w io.Writer) func(done, total int) {
+	start := time.Now()
+	return func(done, total int) {
+		if total < 0 {
+			fmt.Fprintf(w, "\r%d translated  ", done)
+			return
+		}
+		eta := "?"
+		if done > 0 {
+			elapsed := time.Since(start)
+			eta = (elapsed * time.Duration(total-done) / time.Duration(done)).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "\r%d/%d translated, ETA %s  ", done, total, eta)
+		if done == total {
+			fmt.Fprintln(w)
+		}
+	}
 }
 
-type byLine []result
-
-func (a byLine) Len() int           { return len(a) }
-func (a byLine) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byLine) Less(i, j int) bool { return a[i].line < a[j].line }
-
-func translate(s sourceText, apiKey string) (r result) {
-	r.line = s.line
-	resp, err := http.Get(fmt.Sprintf("%s?q=%s&target=%s&source=%s&key=%s", baseURL, url.QueryEscape(s.text), to, from, apiKey))
-	defer resp.Body.Close()
+// writeOutput calls write with the file at path,
+// or with stdout if path is empty, buffered so that writing
+// line by line doesn't cost a system call each.
+// The writer is a *bufio.Writer, for anything that must flush sooner.
+func writeOutput(path string, stdout io.Writer, write func(io.Writer) error) error {
+	if path == "" {
+		return buffered(stdout, write)
+	}
+	f, err := os.Create(path)
 	if err != nil {
-		r.err = err
-		return
-	}
-	// Parse it.
-	dec := json.NewDecoder(resp.Body)
-	var j respJSON
-	if r.err = dec.Decode(&j); r.err != nil {
-		return
-	}
-	// Got some translated text.
-	r.text = j.Data.Translations[0].Text
-	return
+		return err
+	}
+	if err := buffered(f, write); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func startWorkers(from <-chan sourceText, to chan result, wg *sync.WaitGroup) {
-	apiKey := os.Getenv(apiKeyEnvVar)
-	if apiKey == "" {
-		log.Fatalf("No %s supplied", apiKeyEnvVar)
-	}
-	limiter := rate.NewLimiter(maxRequestsPerSec, 1)
-	for i := 0; i < jobs; i++ { // Start workers
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for f := range from {
-				// Throttle & perform request.
-				limiter.Wait(context.TODO())
-				to <- translate(f, apiKey)
-			}
-		}()
+// buffered calls write with w behind a buffer, then flushes it.
+func buffered(w io.Writer, write func(io.Writer) error) error {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	if err := write(bw); err != nil {
+		// Still write out what there is, as unbuffered output would have.
+		bw.Flush()
+		return err
 	}
-
+	return bw.Flush()
 }
 
 func main() {
-	total := make(chan int)
-	toTranslate := make(chan sourceText)
-	translated := make(chan result)
-	var wg sync.WaitGroup
-	startWorkers(toTranslate, translated, &wg)
-	var results byLine
-	wg.Add(1)
-	// Collect results as they arrive
-	go func() {
-		defer wg.Done()
-		expected := -1
-		for {
-			select {
-			case r := <-translated:
-				results = append(results, r)
-				if len(results) == expected {
-					return
-				}
-			case expected = <-total:
-				if len(results) == expected {
-					return
-				}
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run is the whole program; it returns the exit code
+// once every deferred cleanup has happened.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	logger := log.New(stderr, "", log.LstdFlags)
+	o, err := parseFlags(args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		logger.Print(err)
+		return 2
+	}
+	if o.quiet {
+		// Errors that stop the run still go to logger.
+		stderr = io.Discard
+	}
+	stopProfiles, err := startProfiles(o.cpuProfile, o.memProfile, logger)
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+	defer stopProfiles()
+	apiKey, err := lookupKey(o.key, o.keyFile)
+	if o.mock {
+		apiKey = "mock"
+	} else if err != nil && !o.dryRun && o.apiVersion == 2 {
+		logger.Print(err)
+		return 2
+	}
+	t := translate.New(apiKey)
+	t.Source, t.Target, t.Retries, t.BatchSize = o.source, o.target, o.retries, o.batch
+	t.Concurrency, t.Rate, t.Burst, t.MinRate = o.concurrency, o.rate, o.burst, o.minRate
+	t.AutoConcurrency = o.auto
+	t.Dedup, t.SkipUntranslatable, t.NoUnescape = o.dedup, o.skip, o.noUnescape
+	t.CommentPrefix, t.DetectInline = o.commentPrefix, o.detectInline
+	t.Format, t.Detect, t.MaxChars, t.MaxBytes = o.textFormat, o.detect, o.maxChars, o.maxBytes
+	t.BaseURL, t.Normalize, t.Params, t.Gzip = o.baseURL, o.normalize, o.params, o.gzip
+	t.Header = o.header
+	t.FailFast, t.MaxErrors, t.MaxInFlight, t.RequestTimeout = o.failFast, o.maxErrors, o.maxInFlight, o.requestTimeout
+	t.Trim, t.MinInterval, t.Protect = o.trim, o.minInterval, o.protect
+	t.Provider = providers[o.provider](t)
+	t.Warnings = log.New(stderr, "", log.LstdFlags)
+	if o.progress {
+		t.Progress = progress(stderr)
+	}
+	if o.verbose {
+		t.Logger = log.New(stderr, "", log.LstdFlags|log.Lmicroseconds)
+	}
+	if o.trace {
+		t.Trace = log.New(stderr, "", log.LstdFlags|log.Lmicroseconds)
+	}
+	if o.glossary != "" {
+		if t.Glossary, err = readGlossary(o.glossary); err != nil {
+			logger.Print(err)
+			return 1
+		}
+	}
+	if o.cache != "" {
+		if t.Cache, err = translate.OpenCache(o.cache); err != nil {
+			logger.Print(err)
+			return 1
+		}
+	}
+	t.Client = translate.NewClient(t.Concurrency, o.timeout)
+	if o.mock {
+		t.Client = &http.Client{Transport: mockTransport{}}
+	}
+	if t.APIVersion, t.Project = o.apiVersion, o.project; o.apiVersion == 3 {
+		client, project, err := serviceAccount(o.credentials, o)
+		if err != nil && !o.dryRun {
+			logger.Print(err)
+			return 2
+		}
+		if err == nil {
+			t.Client = client
+		}
+		if t.Project == "" {
+			t.Project = project
+		}
+	}
+	// Keeps the cache however run returns; the normal path checks the error.
+	defer t.Close()
+	if o.check {
+		return check(context.Background(), t, stdout, logger)
+	}
+
+	in := stdin
+	if o.in != "" && o.files == nil {
+		f, err := os.Open(o.in)
+		if err != nil {
+			logger.Print(err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	} else if o.files == nil && o.retryFailed == "" && isTerminal(stdin) {
+		// Otherwise it looks like it hangs.
+		fmt.Fprintln(stderr, "Reading lines to translate from the terminal; end with Ctrl-D, or pipe them in or use -in.")
+	}
+	in = decoded(in, o.inputCharset)
+	var lines []string
+	var lineNums []int // Where each of lines came from, with -retry-failed or -sample.
+	if o.retryFailed != "" {
+		if lines, lineNums, err = readFailed(o.retryFailed); err != nil {
+			logger.Print(err)
+			return 1
+		}
+	} else if !o.unordered || o.dryRun {
+		var unterminated bool
+		if o.files != nil {
+			if lines, unterminated, err = readFiles(o); err != nil {
+				logger.Print(err)
+				return 1
 			}
+		} else {
+			if lines, unterminated, err = readLines(in, o); err != nil {
+				logger.Print(err)
+				return 1
+			}
+		}
+		o.noFinalNewline = unterminated
+		if o.warnMixed {
+			for i, l := range lines {
+				warnMixed(stderr, i, l, o)
+			}
+		}
+		if o.whole && len(lines) > 0 {
+			// Sentences split over lines are translated as sentences.
+			lines = []string{strings.Join(lines, "\n")}
+		}
+		if o.sample != 0 || o.sampleN != 0 {
+			lines, lineNums = sample(lines, o.sample, o.sampleN, o.seed)
+		}
+	}
+	var rows [][]string // With -translate-column.
+	if o.column > 0 {
+		lines, rows = splitColumn(lines, o.column, o.delimiter)
+	}
+	if o.dryRun {
+		if err := dryRun(stdout, t, lines, o.countOnly); err != nil {
+			logger.Print(err)
+			return 1
 		}
+		return 0
+	}
+	// On SIGINT or SIGTERM, stop and print whatever is done.
+	// A second signal kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
 	}()
-	// Read input and enqueue jobs
-	i := 0
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		toTranslate <- sourceText{line: i, text: scanner.Text()}
-		i++
-	}
-	close(toTranslate)
-	total <- i
-	wg.Wait()
-	// Sort and print all results
-	sort.Sort(results)
-	for _, r := range results {
-		fmt.Println(r.text)
+	switch t.Provider.(type) {
+	case nil, translate.Google: // CheckLanguages only knows Google's.
+		if err := t.CheckLanguages(ctx); err != nil {
+			logger.Print(err)
+			return 1
+		}
+	}
+	start := time.Now()
+	if o.unordered {
+		return runUnordered(ctx, t, in, o, stdout, stderr, logger, start)
+	}
+	var results []translate.Result
+	if o.checkpoint != "" {
+		results, err = translateCheckpointed(ctx, t, lines, o.checkpoint)
+	} else {
+		results, err = t.TranslateAll(ctx, lines)
+	}
+	interrupted := err != nil && err == ctx.Err()
+	// Out of quota, the rest would fail too, so stop as if interrupted.
+	quota := errors.Is(err, translate.ErrQuotaExceeded)
+	// At -max-errors, write what there is, then stop the same way.
+	tooMany := err == translate.ErrTooManyErrors
+	if le, ok := err.(*translate.LineError); ok && !quota {
+		// -fail-fast stopped at le.
+		if lineNums != nil {
+			le.Line = lineNums[le.Line]
+		}
+		logger.Printf("Stopping: %v", le)
+		return 1
+	}
+	if err != nil && !interrupted && !quota && !tooMany {
+		logger.Print(err)
+		return 1
+	}
+	// Stopped early, the lines it didn't get to are written as failed,
+	// so that -out keeps a line for each and -failed can finish them.
+	all := withUnfinished(results, lines)
+	if rows != nil {
+		joinColumn(all, rows, o.column, o.delimiter)
+	}
+	if lineNums != nil {
+		for i := range all {
+			all[i].Line = lineNums[all[i].Line]
+		}
+	}
+	results = attempted(all)
+	var verifyErr error
+	if o.verify && !interrupted && !quota && !tooMany {
+		o.back, err = backTranslate(ctx, t, results)
+		if interrupted = err != nil && err == ctx.Err(); err != nil && !interrupted {
+			// The translations are still good, so write them without round trips.
+			verifyErr = err
+		}
+	}
+	if err := t.Close(); err != nil {
+		logger.Print(err)
+		return 1
+	}
+	written := all
+	if o.dropComments {
+		written = withoutComments(t, written)
+	}
+	if o.changedOnly {
+		written = changed(written)
+	}
+	if o.retryFailed != "" && o.format == "text" && o.out != "" {
+		err = mergeText(o.out, written, o)
+	} else {
+		err = writeOutput(o.out, stdout, encoded(o.outputCharset, func(w io.Writer) error {
+			return writers[o.format](w, written, o)
+		}))
+	}
+	if err == nil && o.failed != "" {
+		err = writeFailed(o.failed, all)
+	}
+	if err == nil && o.errorReport != "" {
+		err = writeErrorReport(o.errorReport, all, len(lines), o)
+	}
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+	if quota {
+		// Just the one message rather than a line for every failure.
+		logger.Printf("API quota exhausted; translated %d of %d lines. Try again once it resets.", len(results)-countFailed(results), len(lines))
+		return 1
+	}
+	failed := reportErrors(stderr, results, o)
+	if n := countExhausted(results); n > 0 {
+		logger.Printf("%d lines failed on every one of %d attempts; the API may be overloaded, so try again later, or with a lower -rate or more -retries", n, o.retries+1)
+	}
+	if o.stats {
+		printStats(stderr, t.Stats(), len(results)-failed, failed, time.Since(start))
+	}
+	if interrupted {
+		logger.Printf("Interrupted; translated %d of %d lines", len(results), len(lines))
+	}
+	if tooMany {
+		logger.Printf("Stopping after %d failed lines (-max-errors); translated %d of %d lines", failed, len(results)-failed, len(lines))
+	}
+	if verifyErr != nil {
+		logger.Printf("Translating back for -verify: %v", verifyErr)
+	}
+	if failed > 0 || interrupted || tooMany || verifyErr != nil {
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,949 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+)
+
+// runCLI runs the program with args and stdin, from a clean environment,
+// and returns what it wrote and its exit code.
+func runCLI(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	t.Setenv(apiKeyEnvVar, "")
+	t.Setenv(credentialsEnvVar, "")
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// api is a fake v2 API that translates each text to "T:" and the text,
+// or to itself if echo is set. Texts with 坏 in them fail with a 400,
+// as do ones with 回 translated to zh-CN; ones with 额 run out of quota,
+// and ones with 多 get an extra translation.
+type api struct {
+	*httptest.Server
+	echo     bool
+	requests int32 // Translate requests.
+}
+
+func newAPI(t *testing.T) *api {
+	a := &api{}
+	a.Server = httptest.NewServer(a)
+	t.Cleanup(a.Close)
+	return a
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var data interface{}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/languages"):
+		data = map[string]interface{}{"languages": []map[string]string{{"language": "zh-CN"}, {"language": "zh-TW"}, {"language": "en"}}}
+	default:
+		atomic.AddInt32(&a.requests, 1)
+		var tts []map[string]string
+		for _, q := range r.Form["q"] {
+			switch {
+			case strings.Contains(q, "坏"), strings.Contains(q, "回") && r.Form.Get("target") == "zh-CN":
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error": {"code": 400, "message": "Bad text"}}`)
+				return
+			case strings.Contains(q, "额"):
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, `{"error": {"code": 403, "message": "Daily Limit Exceeded", "errors": [{"reason": "dailyLimitExceeded"}]}}`)
+				return
+			}
+			if !a.echo {
+				q = "T:" + q
+			}
+			tts = append(tts, map[string]string{"translatedText": q})
+			if strings.Contains(q, "多") {
+				tts = append(tts, map[string]string{"translatedText": "extra"})
+			}
+		}
+		data = map[string]interface{}{"translations": tts}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// flags are the flags to use a, one batch per line so a failing line
+// fails alone.
+func (a *api) flags(args ...string) []string {
+	return append([]string{"-base-url", a.URL, "-key", "KEY", "-retries=0", "-batch=1", "-concurrency=1"}, args...)
+}
+
+// ten is ten lines to sample from.
+const ten = "一\n二\n三\n四\n五\n六\n七\n八\n九\n十\n"
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		stdout string
+		stderr string // Part of it; "" to expect nothing.
+		code   int
+	}{
+		{name: "lines", args: []string{"-mock"}, stdin: "一\n二\n", stdout: "[zh-TW]一\n[zh-TW]二\n"},
+		{name: "no final newline", args: []string{"-mock"}, stdin: "一\n二", stdout: "[zh-TW]一\n[zh-TW]二"},
+		{name: "blank lines", args: []string{"-mock"}, stdin: "一\n\n二\n", stdout: "[zh-TW]一\n\n[zh-TW]二\n"},
+		{name: "CRLF", args: []string{"-mock"}, stdin: "一\r\n二\r\n", stdout: "[zh-TW]一\n[zh-TW]二\n"},
+		{name: "target", args: []string{"-mock", "-target=ja"}, stdin: "一\n", stdout: "[ja]一\n"},
+		{name: "variant", args: []string{"-mock", "-variant=hk"}, stdin: "一\n", stdout: "[zh-HK]一\n"},
+		{name: "sample n", args: []string{"-mock", "-sample-n=3", "-seed=7", "-line-numbers"}, stdin: ten, stdout: "1\t[zh-TW]一\n3\t[zh-TW]三\n6\t[zh-TW]六\n"},
+		{name: "sample other seed", args: []string{"-mock", "-sample-n=3", "-seed=8", "-line-numbers"}, stdin: ten, stdout: "3\t[zh-TW]三\n5\t[zh-TW]五\n7\t[zh-TW]七\n"},
+		{name: "sample fraction", args: []string{"-mock", "-sample=0.3", "-seed=7", "-line-numbers"}, stdin: ten, stdout: "2\t[zh-TW]二\n3\t[zh-TW]三\n6\t[zh-TW]六\n9\t[zh-TW]九\n"},
+		{name: "sample jsonl", args: []string{"-mock", "-sample-n=2", "-seed=7", "-format=jsonl"}, stdin: ten, stdout: `{"line":1,"source":"一","translation":"[zh-TW]一","status":200}` + "\n" + `{"line":3,"source":"三","translation":"[zh-TW]三","status":200}` + "\n"},
+		{name: "sample more than all", args: []string{"-mock", "-sample-n=5"}, stdin: "一\n二\n", stdout: "[zh-TW]一\n[zh-TW]二\n"},
+		{name: "show source", args: []string{"-mock", "-show-source"}, stdin: "一\n", stdout: "一\t[zh-TW]一\n"},
+		{name: "show source quoted", args: []string{"-mock", "-show-source", "-delimiter=,"}, stdin: "一,二\n", stdout: "\"一,二\",\"[zh-TW]一,二\"\n"},
+		{name: "line numbers", args: []string{"-mock", "-line-numbers", "-line-number-separator=: "}, stdin: "一\n二\n", stdout: "1: [zh-TW]一\n2: [zh-TW]二\n"},
+		{name: "line numbers delimiter", args: []string{"-mock", "-line-numbers", "-delimiter=|"}, stdin: "一\n", stdout: "1|[zh-TW]一\n"},
+		{name: "escaped delimiter", args: []string{"-mock", "-show-source", `-delimiter=\x3b`}, stdin: "一\n", stdout: "一;[zh-TW]一\n"},
+		{name: "delimited csv", args: []string{"-mock", "-format=csv", "-delimiter=;"}, stdin: "一\n", stdout: "source;translation\n一;[zh-TW]一\n"},
+		{name: "jsonl", args: []string{"-mock", "-format=jsonl"}, stdin: "一\n", stdout: `{"line":1,"source":"一","translation":"[zh-TW]一","status":200}` + "\n"},
+		{name: "csv", args: []string{"-mock", "-format=csv"}, stdin: "一,二\n", stdout: "source,translation\n\"一,二\",\"[zh-TW]一,二\"\n"},
+		{name: "tsv", args: []string{"-mock", "-format=tsv"}, stdin: "一\n", stdout: "source\ttranslation\n一\t[zh-TW]一\n"},
+		{name: "table", args: []string{"-mock", "-format=table"}, stdin: "一\n", stdout: "SOURCE  TRANSLATION\n一      [zh-TW]一\n"},
+		{name: "skip untranslatable", args: []string{"-mock", "-skip-untranslatable"}, stdin: "简体\nabc\n", stdout: "[zh-TW]简体\nabc\n"},
+		{name: "changed only", args: []string{"-mock", "-skip-untranslatable", "-changed-only"}, stdin: "abc\n简体\n", stdout: "2\t[zh-TW]简体\n"},
+		{name: "changed only csv", args: []string{"-mock", "-skip-untranslatable", "-changed-only", "-format=csv"}, stdin: "abc\n简体\n", stdout: "line,source,translation\n2,简体,[zh-TW]简体\n"},
+		{name: "changed only table", args: []string{"-mock", "-skip-untranslatable", "-changed-only", "-format=table"}, stdin: "abc\n简体\n", stdout: "LINE  SOURCE  TRANSLATION\n2     简体    [zh-TW]简体\n"},
+		{name: "comments", args: []string{"-mock", "-comment-prefix=#"}, stdin: "# 注\n一\n", stdout: "# 注\n[zh-TW]一\n"},
+		{name: "drop comments", args: []string{"-mock", "-comment-prefix=#", "-drop-comments", "-line-numbers"}, stdin: "# 注\n一\n", stdout: "2\t[zh-TW]一\n"},
+		{name: "NUL records", args: []string{"-mock", "-0"}, stdin: "一\n二\x00三\x00", stdout: "[zh-TW]一\n二\x00[zh-TW]三\x00"},
+		{name: "column", args: []string{"-mock", "-translate-column=2"}, stdin: "id1\t一\tx\nid2\n", stdout: "id1\t[zh-TW]一\tx\nid2\n"},
+		{name: "continuation", args: []string{"-mock", "-continuation", `\`}, stdin: "一\\\n二\n三\n", stdout: "[zh-TW]一二\n[zh-TW]三\n"},
+		{name: "resplit", args: []string{"-mock", "-continuation", `\`, "-resplit"}, stdin: "一\\\n二\n三\n", stdout: "[zh-TW]一\\\n二\n[zh-TW]三\n"},
+		{name: "whole", args: []string{"-mock", "-whole"}, stdin: "一\n二\n", stdout: "[zh-TW]一\n二\n"},
+		{name: "max lines", args: []string{"-mock", "-max-lines=1"}, stdin: "一\n二\n", stdout: "[zh-TW]一\n"},
+		{name: "dedup", args: []string{"-mock", "-dedup"}, stdin: "一\n一\n", stdout: "[zh-TW]一\n[zh-TW]一\n"},
+		{name: "trim", args: []string{"-mock", "-trim"}, stdin: "  一\n", stdout: "  [zh-TW]一\n"},
+		{name: "protect", args: []string{"-mock", "-protect-pattern", `\{\w+\}`}, stdin: "一{x}\n", stdout: "[zh-TW]一{x}\n"},
+		{name: "stream", args: []string{"-mock", "-stream"}, stdin: "一\n二\n三\n", stdout: "[zh-TW]一\n[zh-TW]二\n[zh-TW]三\n"},
+		{name: "unordered", args: []string{"-mock", "-unordered", "-concurrency=1", "-batch=1"}, stdin: "一\n", stdout: "[zh-TW]一\n"},
+		{name: "verify", args: []string{"-mock", "-verify"}, stdin: "一\n", stdout: "[zh-TW]一\tdiffers\n"},
+		{name: "detect", args: []string{"-mock", "-autodetect-inline", "-format=jsonl"}, stdin: "一\n", stdout: `{"line":1,"source":"一","translation":"[zh-TW]一","detected":"zh-CN","status":200}` + "\n"},
+		{name: "dry run", args: []string{"-dry-run", "-count-only", "-batch=2"}, stdin: "一\n二\n三\n", stdout: "2 requests, 3 characters\n"},
+		{name: "quiet", args: []string{"-mock", "-quiet", "-warn-mixed"}, stdin: "这是繁體\n", stdout: "[zh-TW]这是繁體\n"},
+		{name: "warn mixed", args: []string{"-mock", "-warn-mixed"}, stdin: "这是繁體\n", stdout: "[zh-TW]这是繁體\n", stderr: "line 1: warning: mixed simplified and traditional characters"},
+		{name: "check", args: []string{"-mock", "-check"}, stdout: `OK: "测试" translated to "[zh-TW]测试" (zh-TW)`},
+		{name: "stats", args: []string{"-mock", "-stats"}, stdin: "一\n", stdout: "[zh-TW]一\n", stderr: "1 lines translated, 0 failed"},
+		{name: "help", args: []string{"-h"}, stderr: "-autodetect-inline"},
+
+		{name: "no key", stdin: "一\n", stderr: "No API key supplied", code: 2},
+		{name: "no service account", args: []string{"-api-version=3"}, stderr: "No service account supplied", code: 2},
+		{name: "bad credentials", args: []string{"-api-version=3", "-credentials", filepath.Join(t.TempDir(), "missing")}, stderr: "no such file", code: 2},
+		{name: "unknown api version", args: []string{"-api-version=4"}, stderr: "-api-version must be 2 or 3", code: 2},
+		{name: "detect with v3", args: []string{"-api-version=3", "-detect"}, stderr: "-detect needs -api-version=2", code: 2},
+		{name: "unordered failed", args: []string{"-unordered", "-failed=f.jsonl"}, stderr: "-unordered can't be used with -failed, -retry-failed or -error-report", code: 2},
+		{name: "unordered dedup", args: []string{"-unordered", "-dedup"}, stderr: "-unordered can't be used with -dedup", code: 2},
+		{name: "column verify", args: []string{"-translate-column=1", "-verify"}, stderr: "-translate-column can't be used with -unordered or -verify", code: 2},
+		{name: "unordered fail fast", args: []string{"-unordered", "-fail-fast"}, stderr: "-unordered can't be used with -fail-fast", code: 2},
+		{name: "fail fast max errors", args: []string{"-mock", "-fail-fast", "-max-errors=2"}, stderr: "use -fail-fast or -max-errors, not both", code: 2},
+		{name: "negative max errors", args: []string{"-mock", "-max-errors=-1"}, stderr: "-max-errors must not be negative", code: 2},
+		{name: "unordered verify", args: []string{"-unordered", "-verify"}, stderr: "-unordered can't be used with -verify", code: 2},
+		{name: "unordered csv", args: []string{"-unordered", "-format=csv"}, stderr: "-unordered needs -format=text or jsonl", code: 2},
+		{name: "relative base URL", args: []string{"-base-url=/v2"}, stderr: "isn't an absolute URL", code: 2},
+		{name: "unknown variant", args: []string{"-mock", "-variant=cn"}, stderr: `Unknown -variant "cn"`, code: 2},
+		{name: "variant and target", args: []string{"-mock", "-variant=hk", "-target=zh-TW"}, stderr: "-variant=hk means -target=zh-HK", code: 2},
+		{name: "unknown provider", args: []string{"-mock", "-provider=deepl"}, stderr: `Unknown -provider "deepl"`, code: 2},
+		{name: "reserved param", args: []string{"-param", "key=x"}, stderr: "key has its own flag", code: 2},
+		{name: "param with v3", args: []string{"-mock", "-api-version=3", "-param", "model=nmt"}, stderr: "-param needs -api-version=2", code: 2},
+		{name: "bad param", args: []string{"-param", "nope"}, stderr: "want key=value", code: 2},
+		{name: "bad header", args: []string{"-mock", "-header", "nope"}, stderr: "key=value", code: 2},
+		{name: "bad pattern", args: []string{"-mock", "-protect-pattern", "("}, stderr: "missing closing )", code: 2},
+		{name: "max bytes", args: []string{"-mock", "-max-bytes=2"}, stderr: "-max-bytes must be 0 or at least 4", code: 2},
+		{name: "sample both", args: []string{"-dry-run", "-sample=0.5", "-sample-n=2"}, stderr: "use -sample or -sample-n, not both", code: 2},
+		{name: "bad sample", args: []string{"-sample=2"}, stderr: "between 0 and 1", code: 2},
+		{name: "unknown charset", args: []string{"-input-charset=nope"}, stderr: `Unknown charset "nope"`, code: 2},
+		{name: "checkpoint dedup", args: []string{"-checkpoint=c.jsonl", "-dedup"}, stderr: "-checkpoint can't be used with -unordered, -dedup or -fail-fast", code: 2},
+		{name: "whole unordered", args: []string{"-mock", "-whole", "-unordered"}, stderr: "-whole can't be used with", code: 2},
+		{name: "whole resplit", args: []string{"-mock", "-whole", "-continuation", `\`, "-resplit"}, stderr: "-whole can't be used with", code: 2},
+		{name: "resplit alone", args: []string{"-mock", "-resplit"}, stderr: "-resplit needs -continuation", code: 2},
+		{name: "drop comments alone", args: []string{"-mock", "-drop-comments"}, stderr: "-drop-comments needs -comment-prefix", code: 2},
+		{name: "both detects", args: []string{"-mock", "-detect", "-autodetect-inline"}, stderr: "use -detect or -autodetect-inline, not both", code: 2},
+		{name: "check dry run", args: []string{"-mock", "-check", "-count-only"}, stderr: "-check can't be used with -dry-run or -count-only", code: 2},
+		{name: "changed only retry failed", args: []string{"-mock", "-changed-only", "-retry-failed=f.jsonl"}, stderr: "-changed-only can't be used with -retry-failed", code: 2},
+		{name: "quiet verbose", args: []string{"-mock", "-quiet", "-v"}, stderr: "-quiet can't be used with", code: 2},
+		{name: "quiet trace", args: []string{"-mock", "-quiet", "-trace"}, stderr: "-quiet can't be used with -v, -progress, -stats or -trace", code: 2},
+		{name: "mock v3", args: []string{"-mock", "-api-version=3"}, stderr: "-mock needs -api-version=2", code: 2},
+		{name: "mock cache", args: []string{"-mock", "-cache=c.json"}, stderr: "-mock can't be used with -cache or -checkpoint", code: 2},
+		{name: "unknown flag", args: []string{"-nope"}, stderr: "flag provided but not defined", code: 2},
+		{name: "unknown format", args: []string{"-format=xml"}, stderr: `Unknown -format "xml"`, code: 2},
+		{name: "min rate over rate", args: []string{"-rate=5", "-min-rate=10"}, stderr: "-min-rate must be between 0 and -rate", code: 2},
+		{name: "negative burst", args: []string{"-burst=-1"}, stderr: "-burst and -max-inflight must not be negative", code: 2},
+		{name: "bad concurrency", args: []string{"-concurrency=many"}, stderr: "want a number or auto", code: 2},
+		{name: "negative max inflight", args: []string{"-max-inflight=-1"}, stderr: "-burst and -max-inflight must not be negative", code: 2},
+		{name: "negative max lines", args: []string{"-max-lines=-1"}, stderr: "-max-lines and -max-chars must not be negative", code: 2},
+		{name: "unknown text format", args: []string{"-text-format=xml"}, stderr: "-text-format must be text or html", code: 2},
+		{name: "empty delimiter", args: []string{"-delimiter="}, stderr: "-delimiter must not be empty", code: 2},
+		{name: "bad escape", args: []string{`-delimiter=\q`}, stderr: `bad escape in "\\q"`, code: 2},
+		{name: "csv delimiter", args: []string{"-mock", "-format=csv", "-delimiter=ab"}, stderr: "needs -delimiter to be one character", code: 2},
+		{name: "show file without files", args: []string{"-show-file"}, stderr: "-show-file needs -in or input files", code: 2},
+		{name: "unordered files", args: []string{"-unordered", "a.txt", "b.txt"}, stderr: "take only one input file", code: 2},
+		{name: "missing in", args: []string{"-dry-run", "-in", filepath.Join(t.TempDir(), "missing")}, stderr: "no such file", code: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCLI(t, tt.stdin, tt.args...)
+			if tt.name == "check" {
+				stdout = stdout[:strings.Index(stdout, " in ")] // Without the time it took.
+			}
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d; stderr:\n%s", code, tt.code, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("got\n%q\nwant\n%q", stdout, tt.stdout)
+			}
+			if tt.stderr == "" && stderr != "" || !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("got stderr\n%s\nwant it to have %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		stdout string
+		stderr []string // Each must be in it.
+		quiet  bool     // Nothing on stderr.
+		code   int
+		sent   int32 // Translate requests, or -1 not to check.
+	}{
+		{name: "translates", stdin: "一\n二\n", stdout: "T:一\nT:二\n", sent: 2},
+		{name: "BOM", stdin: "\uFEFF一\n", stdout: "T:一\n", sent: 1},
+		{name: "BOM only at the start", stdin: "\uFEFF一\n\uFEFF二\n", stdout: "T:一\nT:\uFEFF二\n", sent: 2},
+		{name: "unordered", args: []string{"-unordered"}, stdin: "一\n", stdout: "T:一\n", sent: 1},
+		{name: "failed line", stdin: "一\n坏\n三\n", stdout: "T:一\n\nT:三\n", stderr: []string{"line 2: API error 400: Bad text"}, code: 1, sent: 3},
+		{name: "quota", stdin: "一\n额\n三\n四\n", stdout: "T:一\n\n\n\n", stderr: []string{"API quota exhausted; translated 1 of 4 lines"}, code: 1, sent: 2},
+		{name: "quota drop comments", args: []string{"-comment-prefix=#", "-drop-comments"}, stdin: "# 注\n一\n额\n三\n", stdout: "T:一\n\n\n", stderr: []string{"API quota exhausted; translated 2 of 4 lines"}, code: 1, sent: 2},
+		{name: "quota unordered", args: []string{"-unordered"}, stdin: "一\n额\n三\n四\n", stdout: "T:一\n\n", stderr: []string{"API quota exhausted; translated 1 lines"}, code: 1, sent: 2},
+		{name: "stats", args: []string{"-stats"}, stdin: "一\n坏\n", stdout: "T:一\n\n", stderr: []string{"1 lines translated, 1 failed", "3 requests, 0 retries", "200 OK: 2", "400 Bad Request: 1"}, code: 1, sent: 2},
+		{name: "fail fast", args: []string{"-fail-fast"}, stdin: "一\n坏\n三\n四\n", stderr: []string{"Stopping: line 2: API error 400"}, code: 1, sent: -1},
+		{name: "max errors", args: []string{"-max-errors=2"}, stdin: "坏1\n坏2\n坏3\n坏4\n五\n六\n", stdout: "\n\n\n\n\n\n", stderr: []string{"Stopping after 2 failed lines (-max-errors); translated 0 of 6 lines"}, code: 1, sent: 2},
+		{name: "max errors unordered", args: []string{"-max-errors=2", "-unordered"}, stdin: "坏1\n坏2\n坏3\n坏4\n五\n六\n", stdout: "\n\n", stderr: []string{"Stopping after 2 failed lines (-max-errors)"}, code: 1, sent: 2},
+		{name: "extra translation", stdin: "多\n", stdout: "T:多\n", stderr: []string{"warning: got 2 translations for a batch of 1"}, sent: 1},
+		{name: "extra translation quiet", args: []string{"-quiet"}, stdin: "多\n", stdout: "T:多\n", quiet: true, sent: 1},
+		{name: "check", args: []string{"-check"}, stdout: `OK: "测试" translated to "T:测试" (zh-TW)`, sent: 1},
+		{name: "unsupported language", args: []string{"-target=xx"}, stdin: "一\n", stderr: []string{`unsupported language "xx"`}, code: 1, sent: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAPI(t)
+			stdout, stderr, code := runCLI(t, tt.stdin, a.flags(tt.args...)...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d; stderr:\n%s", code, tt.code, stderr)
+			}
+			if tt.name == "check" {
+				stdout = stdout[:strings.Index(stdout, " in ")] // Without the time it took.
+			}
+			if stdout != tt.stdout {
+				t.Errorf("got\n%q\nwant\n%q", stdout, tt.stdout)
+			}
+			for _, s := range tt.stderr {
+				if !strings.Contains(stderr, s) {
+					t.Errorf("got stderr\n%s\nwant it to have %q", stderr, s)
+				}
+			}
+			if tt.quiet && stderr != "" {
+				t.Errorf("got stderr\n%s\nwant nothing", stderr)
+			}
+			if strings.Contains(stderr, "KEY") {
+				t.Errorf("the key is in stderr:\n%s", stderr)
+			}
+			if tt.sent >= 0 && a.requests != tt.sent {
+				t.Errorf("%d requests, want %d", a.requests, tt.sent)
+			}
+		})
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	var in strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&in, "一%d\n坏%d\n一%d\n二\n坏%d\n", i%3, i%4, i%3, i)
+	}
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"text", nil},
+		{"dedup", []string{"-dedup"}},
+		{"jsonl", []string{"-dedup", "-format=jsonl"}},
+		{"line numbers", []string{"-line-numbers", "-show-source"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-concurrency=8", "-rate=10000"}, tt.args...)
+			a := newAPI(t)
+			stdout, stderr, code := runCLI(t, in.String(), a.flags(args...)...)
+			if code != 1 || !strings.Contains(stderr, "line 2: API error 400") {
+				t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+			}
+			for i := 0; i < 5; i++ {
+				stdout2, stderr2, _ := runCLI(t, in.String(), a.flags(args...)...)
+				if stdout2 != stdout {
+					t.Fatalf("run %d stdout:\n%s\nfirst:\n%s", i+2, stdout2, stdout)
+				}
+				if stderr2 != stderr {
+					t.Fatalf("run %d stderr:\n%s\nfirst:\n%s", i+2, stderr2, stderr)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	glossary := filepath.Join(t.TempDir(), "glossary.tsv")
+	os.WriteFile(glossary, []byte("甲\t乙\n乙\t甲\n"), 0o644)
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		stdout string
+		stderr string
+		code   int
+	}{
+		{name: "match", stdin: "一\n二\n", stdout: "一\tmatch\n二\tmatch\n"},
+		// Back through the glossary, 乙 would be 甲 again.
+		{name: "no glossary back", args: []string{"-glossary", glossary}, stdin: "甲\n", stdout: "乙\tdiffers\n"},
+		{name: "progress once", args: []string{"-progress"}, stdin: "一\n", stdout: "一\tmatch\n", stderr: "\r1/1 translated"},
+		{name: "failed back", args: []string{"-fail-fast"}, stdin: "一\n回\n", stdout: "一\t\n回\t\n", stderr: "Translating back for -verify: line 2: API error 400: Bad text", code: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAPI(t)
+			a.echo = true
+			stdout, stderr, code := runCLI(t, tt.stdin, a.flags(append(tt.args, "-verify")...)...)
+			if code != tt.code || stdout != tt.stdout {
+				t.Errorf("exit code %d, got %q, want %d, %q; stderr:\n%s", code, stdout, tt.code, tt.stdout, stderr)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("got stderr\n%s\nwant it to have %q", stderr, tt.stderr)
+			}
+			if n := strings.Count(stderr, "translated, ETA"); tt.name == "progress once" && n != 2 {
+				t.Errorf("progress printed %d times, want twice for one line", n)
+			}
+		})
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		bad    string            // What makes lines fail, replaced with 好 to retry them.
+		errors []errorReportLine // Without their File and Status.
+		want   string            // -out after retrying.
+		sent   int32             // Translate requests to retry.
+	}{
+		{name: "failed line", stdin: "一\n坏\n三\n", bad: "坏", errors: []errorReportLine{
+			{Line: 2, Source: "坏", Error: "API error 400: Bad text", Attempts: 1},
+		}, want: "T:一\nT:好\nT:三\n", sent: 1},
+		{name: "quota", stdin: "一\n额\n三\n四\n", bad: "额", errors: []errorReportLine{
+			{Line: 2, Source: "额", Error: "API error 403: Daily Limit Exceeded", Attempts: 1},
+			{Line: 3, Source: "三", Error: errNotAttempted.Error()},
+			{Line: 4, Source: "四", Error: errNotAttempted.Error()},
+		}, want: "T:一\nT:好\nT:三\nT:四\n", sent: 3},
+		{name: "max errors", args: []string{"-max-errors=1"}, stdin: "坏\n二\n", bad: "坏", errors: []errorReportLine{
+			{Line: 1, Source: "坏", Error: "API error 400: Bad text", Attempts: 1},
+			{Line: 2, Source: "二", Error: errNotAttempted.Error()},
+		}, want: "T:好\nT:二\n", sent: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			out, failed, report := filepath.Join(dir, "out.txt"), filepath.Join(dir, "failed.jsonl"), filepath.Join(dir, "report.json")
+			a := newAPI(t)
+			_, _, code := runCLI(t, tt.stdin, a.flags(append(tt.args, "-out", out, "-failed", failed, "-error-report", report)...)...)
+			if code != 1 {
+				t.Fatalf("exit code %d, want 1", code)
+			}
+			var r errorReport
+			b, _ := os.ReadFile(report)
+			if err := json.Unmarshal(b, &r); err != nil {
+				t.Fatal(err)
+			}
+			for i := range r.Errors {
+				r.Errors[i].Status = 0
+			}
+			if r.Lines != strings.Count(tt.stdin, "\n") || r.Failed != len(tt.errors) || !reflect.DeepEqual(r.Errors, tt.errors) {
+				t.Errorf("got report %+v, want errors %+v", r, tt.errors)
+			}
+
+			// Once the API takes them, only the failed lines are sent again.
+			b, _ = os.ReadFile(failed)
+			os.WriteFile(failed, bytes.ReplaceAll(b, []byte(tt.bad), []byte("好")), 0o666)
+			a = newAPI(t)
+			if _, stderr, code := runCLI(t, "", a.flags("-out", out, "-retry-failed", failed)...); code != 0 {
+				t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+			}
+			if b, _ := os.ReadFile(out); string(b) != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", b, tt.want)
+			}
+			if a.requests != tt.sent {
+				t.Errorf("%d requests, want %d", a.requests, tt.sent)
+			}
+		})
+	}
+}
+
+func TestMergeShortOutput(t *testing.T) {
+	dir := t.TempDir()
+	out, failed := filepath.Join(dir, "out.txt"), filepath.Join(dir, "failed.jsonl")
+	os.WriteFile(out, []byte("T:一\n"), 0o666)
+	os.WriteFile(failed, []byte(`{"line": 3, "source": "三"}`+"\n"), 0o666)
+	a := newAPI(t)
+	_, stderr, code := runCLI(t, "", a.flags("-out", out, "-retry-failed", failed)...)
+	if code != 1 || !strings.Contains(stderr, "has no line 3") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name string
+		flag string
+	}{
+		{"cache", "-cache"},
+		{"checkpoint", "-checkpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "state.json")
+			a := newAPI(t)
+			if _, stderr, code := runCLI(t, "一\n二\n", a.flags(tt.flag, file)...); code != 0 {
+				t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+			}
+			a = newAPI(t)
+			stdout, stderr, code := runCLI(t, "一\n二\n三\n", a.flags(tt.flag, file)...)
+			if code != 0 || stdout != "T:一\nT:二\nT:三\n" {
+				t.Errorf("exit code %d, got %q; stderr:\n%s", code, stdout, stderr)
+			}
+			if a.requests != 1 {
+				t.Errorf("%d requests, want only the new line's", a.requests)
+			}
+		})
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  []string // Flags for the first run, then the second.
+		second []string
+		sent   int32 // Translate requests in the second run.
+		code   int
+		stderr string
+	}{
+		{name: "normalized", first: []string{"-normalize"}, second: []string{"-normalize"}, sent: 0},
+		{name: "other target", second: []string{"-target=en"}, code: 1, stderr: `has translations to "zh-TW", not "en"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+			const in = "e\u0301一\n"
+			a := newAPI(t)
+			if _, stderr, code := runCLI(t, in, a.flags(append(tt.first, "-checkpoint", file)...)...); code != 0 {
+				t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+			}
+			a = newAPI(t)
+			_, stderr, code := runCLI(t, in, a.flags(append(tt.second, "-checkpoint", file)...)...)
+			if code != tt.code || !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("exit code %d, want %d; stderr:\n%s", code, tt.code, stderr)
+			}
+			if a.requests != tt.sent {
+				t.Errorf("%d requests, want %d", a.requests, tt.sent)
+			}
+		})
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.txt")
+	if err := os.WriteFile(path, []byte("一\n\n三\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, _, err := readLines(f, &options{}); err != nil || !reflect.DeepEqual(got, []string{"一", "", "三"}) {
+		t.Errorf("got %q, %v from %s", got, err, path)
+	}
+	tests := []struct {
+		in           string
+		o            options
+		want         []string
+		unterminated bool
+	}{
+		{"", options{}, nil, false},
+		{"一\n二\n", options{}, []string{"一", "二"}, false},
+		{"一\n二", options{}, []string{"一", "二"}, true},
+		{"一\n二", options{maxLines: 1}, []string{"一"}, false},
+		{"\uFEFF一\n\uFEFF二\n", options{}, []string{"一", "\uFEFF二"}, false},
+		{"一\n二\x00三\x00", options{null: true}, []string{"一\n二", "三"}, false},
+		{"一\\\n二\n三\\\n", options{continuation: `\`}, []string{"一二", "三"}, false},
+		{"一\\\n二\n", options{continuation: `\`, resplit: true}, []string{"一\n二"}, false},
+		{"一\n二\x00三", options{null: true}, []string{"一\n二", "三"}, true},
+	}
+	for _, tt := range tests {
+		got, unterminated, err := readLines(strings.NewReader(tt.in), &tt.o)
+		if err != nil || !reflect.DeepEqual(got, tt.want) || unterminated != tt.unterminated {
+			t.Errorf("readLines(%q) with %+v = %q, %v, %v, want %q, %v", tt.in, tt.o, got, unterminated, err, tt.want, tt.unterminated)
+		}
+	}
+}
+
+func TestReadGlossary(t *testing.T) {
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.tsv"), filepath.Join(dir, "bad.tsv")
+	os.WriteFile(good, []byte("软件\t軟體\r\n\n一\t\n"), 0o666)
+	os.WriteFile(bad, []byte("软件\t軟體\n软件\n"), 0o666)
+	got, err := readGlossary(good)
+	if want := map[string]string{"软件": "軟體", "一": ""}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+	if _, err := readGlossary(bad); err == nil || !strings.Contains(err.Error(), "bad.tsv:2:") {
+		t.Errorf("got error %v, want one for line 2", err)
+	}
+}
+
+func TestWriters(t *testing.T) {
+	results := []translate.Result{
+		{Line: 0, Source: "一,二", Text: "T:一", Status: 200, Latency: 1500 * time.Microsecond},
+		{Line: 1, Source: "<二>", Err: errors.New("API error 400: Bad text"), Status: 400},
+	}
+	tests := []struct {
+		format string
+		o      options
+		want   string
+	}{
+		{"text", options{}, "T:一\n\n"},
+		{"text", options{null: true}, "T:一\x00\x00"},
+		{"text", options{noFinalNewline: true}, "T:一\n"},
+		{"text", options{lineNumbers: true, lineNumberSep: "\t"}, "1\tT:一\n2\t\n"},
+		{"text", options{showSource: true, delimiter: "\t"}, "一,二\tT:一\n<二>\t\n"},
+		{"text", options{showSource: true, delimiter: ","}, "\"一,二\",T:一\n<二>,\n"},
+		{"jsonl", options{}, `{"line":1,"source":"一,二","translation":"T:一","status":200}` + "\n" + `{"line":2,"source":"<二>","translation":"","error":"API error 400: Bad text","status":400}` + "\n"},
+		{"jsonl", options{stats: true}, `{"line":1,"source":"一,二","translation":"T:一","status":200,"latency_ms":1.5}` + "\n" + `{"line":2,"source":"<二>","translation":"","error":"API error 400: Bad text","status":400}` + "\n"},
+		{"csv", options{}, "source,translation\n\"一,二\",T:一\n<二>,\n"},
+		{"tsv", options{}, "source\ttranslation\n一,二\tT:一\n<二>\t\n"},
+		{"table", options{}, "SOURCE  TRANSLATION\n一,二   T:一\n<二>    error: API error 400: Bad text\n"},
+		{"text", options{verify: true, delimiter: "\t", back: map[int]string{0: "一,二"}}, "T:一\tmatch\n\t\n"},
+		{"csv", options{verify: true, back: map[int]string{0: "一"}}, "source,translation,round_trip\n\"一,二\",T:一,differs\n<二>,,\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := writers[tt.format](&b, results, &tt.o); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("-format=%s with %+v wrote\n%s\nwant\n%s", tt.format, tt.o, b.String(), tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"一二", 4, "一二"},
+		{"一二三四", 5, "一二…"},
+		{"abcdef", 4, "abc…"},
+		{"a\tb\nc", 10, "a b c"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.in, tt.max); got != tt.want || cells(got) > tt.max {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	s := translate.Stats{Requests: 3, Retries: 1, Statuses: map[int]int{503: 1, 200: 2}, BytesSent: 10, BytesReceived: 20}
+	var b strings.Builder
+	printStats(&b, s, 4, 1, 1500*time.Millisecond)
+	want := "4 lines translated, 1 failed in 1.5s\n3 requests, 1 retries, 0 without a response\n  200 OK: 2\n  503 Service Unavailable: 1\n10 bytes sent, 20 received\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	results := []translate.Result{
+		{Line: 0, Text: "T:一"},
+		{Line: 1, Err: errors.New("API error 400: Bad text")},
+		{Line: 2, Err: errors.New("no translations in response")},
+	}
+	var b strings.Builder
+	if n := reportErrors(&b, results, nil); n != 2 {
+		t.Errorf("got %d failed, want 2", n)
+	}
+	if want := "line 2: API error 400: Bad text\nline 3: no translations in response\n"; b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"target": "ja", "show-source": true, "rate": 2.5, "param": ["model=nmt", "x=y"]}`), 0o666)
+	t.Setenv("SIMP2TRAD_TARGET", "ko")
+	t.Setenv("SIMP2TRAD_RATE", "7")
+	o, err := parseFlags([]string{"-config", path, "-target=en"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.target != "en" || !o.showSource || o.rate != 7 {
+		t.Errorf("got -target=%s -show-source=%v -rate=%v, want the command line to win over the environment, and both over the file", o.target, o.showSource, o.rate)
+	}
+	if want := (url.Values{"model": {"nmt"}, "x": {"y"}}); !reflect.DeepEqual(o.params, want) {
+		t.Errorf("got params %v, want %v", o.params, want)
+	}
+	for _, config := range []string{`{"tagret": "en"}`, `{"config": "other.json"}`, `{"source": {"name": "ja"}}`, `{"source": `} {
+		os.WriteFile(path, []byte(config), 0o666)
+		if _, err := parseFlags([]string{"-config", path}, io.Discard); err == nil || !strings.Contains(err.Error(), path+": ") {
+			t.Errorf("config %s: got error %v, want one naming the file", config, err)
+		}
+	}
+	t.Setenv("SIMP2TRAD_RETRIES", "many")
+	if _, err := parseFlags(nil, io.Discard); err == nil || !strings.Contains(err.Error(), "SIMP2TRAD_RETRIES: ") {
+		t.Errorf("got error %v, want one naming the variable", err)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	write := func(name, s string) {
+		if err := os.WriteFile(path(name), []byte(s), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "一\n二\n")
+	write("b.txt", "三\n")
+	write("key", "  FILEKEY\n")
+	write("glossary.tsv", "一\t壹\n\n")
+	write("config.json", `{"target": "ja", "show-source": true}`)
+	write("gb.txt", "\xd2\xbb\n") // 一 in GB18030.
+	tests := []struct {
+		name   string
+		env    map[string]string
+		args   []string
+		stdout string
+		file   string // Of -out, if it's set.
+	}{
+		{name: "in", args: []string{"-mock", "-in", path("a.txt")}, stdout: "[zh-TW]一\n[zh-TW]二\n"},
+		{name: "out", args: []string{"-mock", "-in", path("a.txt"), "-out", path("out.txt")}, file: "[zh-TW]一\n[zh-TW]二\n"},
+		{name: "files", args: []string{"-mock", "-format=jsonl", "-show-file", "-file-line-numbers", path("a.txt"), path("b.txt")},
+			stdout: fmt.Sprintf(`{"line":1,"file":%[1]q,"source":"一","translation":"[zh-TW]一","status":200}
+{"line":2,"file":%[1]q,"source":"二","translation":"[zh-TW]二","status":200}
+{"line":1,"file":%[2]q,"source":"三","translation":"[zh-TW]三","status":200}
+`, path("a.txt"), path("b.txt"))},
+		{name: "glossary", args: []string{"-mock", "-glossary", path("glossary.tsv"), path("a.txt")}, stdout: "[zh-TW]壹\n[zh-TW]二\n"},
+		{name: "config", args: []string{"-mock", "-config", path("config.json"), path("b.txt")}, stdout: "三\t[ja]三\n"},
+		{name: "config loses to flags", args: []string{"-mock", "-config", path("config.json"), "-target=en", path("b.txt")}, stdout: "三\t[en]三\n"},
+		{name: "env", env: map[string]string{"SIMP2TRAD_TARGET": "ja"}, args: []string{"-mock", path("b.txt")}, stdout: "[ja]三\n"},
+		{name: "env loses to flags", env: map[string]string{"SIMP2TRAD_TARGET": "ja"}, args: []string{"-mock", "-target=en", path("b.txt")}, stdout: "[en]三\n"},
+		{name: "input charset", args: []string{"-mock", "-input-charset=gb18030", "-target=ja", path("gb.txt")}, stdout: "[ja]一\n"},
+		{name: "output charset", args: []string{"-mock", "-output-charset=gb18030", "-input-charset=gb18030", "-target=ja", path("gb.txt")}, stdout: "[ja]\xd2\xbb\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			stdout, stderr, code := runCLI(t, "", tt.args...)
+			if code != 0 || stderr != "" {
+				t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("got\n%q\nwant\n%q", stdout, tt.stdout)
+			}
+			if tt.file != "" {
+				if b, _ := os.ReadFile(path("out.txt")); string(b) != tt.file {
+					t.Errorf("wrote\n%q\nwant\n%q", b, tt.file)
+				}
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int // 0 for no API at all.
+		body   string
+		stdout string
+		stderr string
+		code   int
+	}{
+		{"ok", http.StatusOK, `{"data": {"translations": [{"translatedText": "測試"}]}}`, `OK: "测试" translated to "測試" (zh-TW) in `, "", 0},
+		{"forbidden", http.StatusForbidden, `{"error": {"code": 403, "message": "Requests from this referer are blocked.", "errors": [{"reason": "forbidden"}]}}`, "", "API key or credentials rejected: API error 403: Requests from this referer are blocked.", 1},
+		{"bad key", http.StatusBadRequest, `{"error": {"code": 400, "message": "API key not valid. Please pass a valid API key.", "errors": [{"reason": "badRequest"}]}}`, "", "API key or credentials rejected: API error 400: API key not valid.", 1},
+		{"bad request", http.StatusBadRequest, `{"error": {"code": 400, "message": "Invalid value"}}`, "", "The API failed: API error 400: Invalid value", 1},
+		{"quota", http.StatusForbidden, `{"error": {"code": 403, "message": "Daily Limit Exceeded", "errors": [{"reason": "dailyLimitExceeded"}]}}`, "", "API quota exhausted: API error 403", 1},
+		{"no connection", 0, "", "", "Couldn't reach the API: ", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			if tt.status == 0 {
+				srv.Close()
+			} else {
+				defer srv.Close()
+			}
+			stdout, stderr, code := runCLI(t, "", "-check", "-base-url", srv.URL, "-key", "KEY", "-retries=0")
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d; stderr:\n%s", code, tt.code, stderr)
+			}
+			if !strings.HasPrefix(stdout, tt.stdout) || tt.stdout == "" && stdout != "" {
+				t.Errorf("got %q, want %q", stdout, tt.stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) || tt.stderr == "" && stderr != "" {
+				t.Errorf("got stderr\n%s\nwant %q", stderr, tt.stderr)
+			}
+			if strings.Contains(stderr, "KEY") {
+				t.Errorf("the key is in stderr:\n%s", stderr)
+			}
+		})
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		stderr string
+	}{
+		{"unknown key", `{"target": "ja", "tagret": "en"}`, `unknown option "tagret"`},
+		{"config in config", `{"config": "other.json"}`, `unknown option "config"`},
+		{"bad value", `{"retries": "many"}`, `retries: parse error`},
+		{"bad type", `{"target": {"name": "ja"}}`, `"target" must be a string, number or boolean`},
+		{"not json", `{"target": `, `unexpected end of JSON input`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			os.WriteFile(path, []byte(tt.config), 0o666)
+			_, stderr, code := runCLI(t, "一\n", "-mock", "-config", path)
+			if code != 2 || !strings.Contains(stderr, path+": ") || !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("exit code %d, want 2; stderr:\n%s\nwant it to name %s and have %q", code, stderr, path, tt.stderr)
+			}
+		})
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, empty := filepath.Join(dir, "key"), filepath.Join(dir, "empty")
+	os.WriteFile(keyFile, []byte(" FILE\n"), 0o666)
+	os.WriteFile(empty, []byte("\n"), 0o666)
+	tests := []struct {
+		key, keyFile, env string
+		want, wantErr     string
+	}{
+		{"FLAG", keyFile, "ENV", "FLAG", ""},
+		{"", keyFile, "ENV", "FILE", ""},
+		{"", "", "ENV", "ENV", ""},
+		{"", "", "", "", "No API key supplied"},
+		{"", empty, "ENV", "", "is empty"},
+		{"", filepath.Join(dir, "missing"), "ENV", "", "no such file"},
+	}
+	for _, tt := range tests {
+		t.Setenv(apiKeyEnvVar, tt.env)
+		got, err := lookupKey(tt.key, tt.keyFile)
+		if got != tt.want || tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("lookupKey(%q, %q) with %s=%q = %q, %v, want %q, %q", tt.key, tt.keyFile, apiKeyEnvVar, tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	tr := translate.New("SECRET")
+	tr.BatchSize = 2
+	var b strings.Builder
+	if err := dryRun(&b, tr, []string{"一", "二", "三四"}, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "GET ") || lines[2] != "2 requests, 4 characters" {
+		t.Errorf("got\n%s", b.String())
+	}
+	if strings.Contains(b.String(), "SECRET") {
+		t.Errorf("the key is in\n%s", b.String())
+	}
+}
+
+func TestCacheGlossary(t *testing.T) {
+	dir := t.TempDir()
+	cache, glossary := filepath.Join(dir, "cache.json"), filepath.Join(dir, "glossary.tsv")
+	os.WriteFile(glossary, []byte("一\t壹\n"), 0o644)
+	a := newAPI(t)
+	if stdout, stderr, code := runCLI(t, "一\n", a.flags("-cache", cache)...); code != 0 || stdout != "T:一\n" {
+		t.Fatalf("exit code %d, got %q; stderr:\n%s", code, stdout, stderr)
+	}
+	stdout, stderr, code := runCLI(t, "一\n", a.flags("-cache", cache, "-glossary", glossary)...)
+	if code != 0 || stdout != "T:壹\n" {
+		t.Errorf("exit code %d, got %q, want the glossary used; stderr:\n%s", code, stdout, stderr)
+	}
+}
+
+func TestStreamDelayed(t *testing.T) {
+	t.Setenv(apiKeyEnvVar, "")
+	t.Setenv(credentialsEnvVar, "")
+	stdin, feed := io.Pipe()
+	read, stdout := io.Pipe()
+	var stderr bytes.Buffer
+	done := make(chan int)
+	go func() {
+		code := run([]string{"-mock", "-stream"}, stdin, stdout, &stderr)
+		stdout.Close()
+		done <- code
+	}()
+	lines := bufio.NewReader(read)
+	// Each line is written while the input is still open, before the next arrives.
+	for _, l := range []string{"一", "二", "三"} {
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintln(feed, l)
+		got := make(chan string, 1)
+		go func() {
+			s, _ := lines.ReadString('\n')
+			got <- s
+		}()
+		select {
+		case s := <-got:
+			if want := "[zh-TW]" + l + "\n"; s != want {
+				t.Fatalf("got %q, want %q", s, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s wasn't written before the next line arrived", l)
+		}
+	}
+	feed.Close()
+	if code := <-done; code != 0 {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr.String())
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	cpu, mem := filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+	if _, stderr, code := runCLI(t, "一\n", "-dry-run", "-cpuprofile", cpu, "-memprofile", mem); code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	for _, path := range []string{cpu, mem} {
+		// pprof files are gzipped protocol buffers.
+		if b, err := os.ReadFile(path); err != nil || len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+			t.Errorf("%s isn't a pprof file: %v", path, err)
+		}
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	const n = 100000
+	var out bytes.Buffer
+	err := writeOutput("", &out, func(w io.Writer) error {
+		for i := 0; i < n; i++ {
+			fmt.Fprintln(w, i)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != n || lines[0] != "0" || lines[n-1] != fmt.Sprint(n-1) {
+		t.Errorf("got %d lines, want %d", len(lines), n)
+	}
+
+	out.Reset()
+	err = writeOutput("", &out, func(w io.Writer) error {
+		io.WriteString(w, "partial\n")
+		return io.ErrUnexpectedEOF
+	})
+	if err != io.ErrUnexpectedEOF || out.String() != "partial\n" {
+		t.Errorf("got %v, %q, want the error and what was written before it", err, out.String())
+	}
+}
+
+// BenchmarkWriteOutput writes 100k lines of text output to /dev/null,
+// through writeOutput's buffer and straight to the file.
+func BenchmarkWriteOutput(b *testing.B) {
+	results := make([]translate.Result, 100000)
+	for i := range results {
+		results[i] = translate.Result{Line: i, Source: "你觉得紧张吗？", Text: "你覺得緊張嗎？"}
+	}
+	o := &options{delimiter: "\t"}
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	write := func(w io.Writer) error { return writeText(w, results, o) }
+	b.Run("buffered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := writeOutput("", f, write); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unbuffered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := write(f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSample(t *testing.T) {
+	lines := []string{"一", "二", "三", "四", "五", "六"}
+	picked, nums := sample(lines, 0, 3, 1)
+	if len(picked) != 3 || !sort.IntsAreSorted(nums) {
+		t.Fatalf("got %q at %v, want 3 lines in order", picked, nums)
+	}
+	for i, n := range nums {
+		if picked[i] != lines[n] {
+			t.Errorf("line %d is %q, want %q", n, picked[i], lines[n])
+		}
+	}
+	again, _ := sample(lines, 0, 3, 1)
+	if !reflect.DeepEqual(again, picked) {
+		t.Errorf("same seed picked %q, then %q", picked, again)
+	}
+	if all, _ := sample(lines, 0, 10, 1); len(all) != len(lines) {
+		t.Errorf("-sample-n over the line count picked %d lines", len(all))
+	}
+	if none, _ := sample(lines, 0.0001, 0, 1); len(none) > 1 {
+		t.Errorf("a tiny -sample picked %d lines", len(none))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mockTransport answers v2 API requests itself, with each text
+// translated to target by prefixing it with [target], so the rest
+// of the program can be run without an API key or network.
+type mockTransport struct{}
+
+func (mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	q := req.URL.Query()
+	if req.Body != nil {
+		var body io.Reader = req.Body
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			body = zr
+		}
+		b, err := io.ReadAll(body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil, err
+		}
+		for k, vs := range form {
+			q[k] = append(q[k], vs...)
+		}
+	}
+	var data interface{}
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/languages"):
+		var langs []map[string]string
+		for _, l := range []string{q.Get("target"), "zh-CN", "zh-TW", "zh-HK", "en", "ja"} {
+			langs = append(langs, map[string]string{"language": l})
+		}
+		data = map[string]interface{}{"languages": langs}
+	case strings.HasSuffix(req.URL.Path, "/detect"):
+		var detections [][]map[string]string
+		for range q["q"] {
+			detections = append(detections, []map[string]string{{"language": "zh-CN"}})
+		}
+		data = map[string]interface{}{"detections": detections}
+	default:
+		var translations []map[string]string
+		for _, text := range q["q"] {
+			tt := map[string]string{"translatedText": "[" + q.Get("target") + "]" + text}
+			if q.Get("source") == "" {
+				tt["detectedSourceLanguage"] = "zh-CN"
+			}
+			translations = append(translations, tt)
+		}
+		data = map[string]interface{}{"translations": translations}
+	}
+	b, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(b)),
+		Request:    req,
+	}, nil
+}
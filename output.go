@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+)
+
+// writers holds the output formats, by -format name.
+var writers = map[string]func(io.Writer, []translate.Result, *options) error{
+	"text":  writeText,
+	"jsonl": writeJSONL,
+	"csv":   writeCSV(','),
+	"tsv":   writeCSV('\t'),
+	"table": writeTable,
+}
+
+// writeText prints the translated text of each result, one per line
+// (or per NUL-terminated record with -0),
+// after its source text if -show-source is set
+// and before how it round-trips if -verify is,
+// and after its line number if -line-numbers is.
+// With -resplit, lines joined by -continuation are split again.
+// The last line ends like the input's did.
+func writeText(w io.Writer, results []translate.Result, o *options) error {
+	end := "\n"
+	if o.null {
+		end = "\x00"
+	}
+	for i, r := range results {
+		line := resplit(r.Text, o)
+		if o.showSource || o.verify {
+			fields := []string{line}
+			if o.showSource {
+				fields = []string{resplit(r.Source, o), line}
+			}
+			if o.verify {
+				fields = append(fields, roundTrip(r, o))
+			}
+			line = joinFields(fields, o.delimiter)
+		}
+		if o.lineNumbers {
+			line = strconv.Itoa(lineNumber(r.Line, o)+1) + o.lineNumberSep + line
+		}
+		if i == len(results)-1 && o.noFinalNewline {
+			end = ""
+		}
+		if _, err := io.WriteString(w, line+end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinFields joins fields with delim, quoting any field that contains
+// delim, a quote or a newline the way CSV does, so the columns stay apart.
+func joinFields(fields []string, delim string) string {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		if strings.Contains(f, delim) || strings.ContainsAny(f, "\"\r\n") {
+			f = `"` + strings.ReplaceAll(f, `"`, `""`) + `"`
+		}
+		quoted[i] = f
+	}
+	return strings.Join(quoted, delim)
+}
+
+type jsonLine struct {
+	Line        int     `json:"line"`           // From 1.
+	File        string  `json:"file,omitempty"` // With -show-file.
+	Source      string  `json:"source"`
+	Translation string  `json:"translation"`
+	Error       string  `json:"error,omitempty"`
+	Detected    string  `json:"detected,omitempty"`   // With -detect or -autodetect-inline.
+	Status      int     `json:"status,omitempty"`     // Of the request; absent if there was none.
+	LatencyMS   float64 `json:"latency_ms,omitempty"` // With -stats, since it varies from run to run.
+
+	// With -verify.
+	BackTranslation string `json:"back_translation,omitempty"`
+	RoundTrip       string `json:"round_trip,omitempty"`
+}
+
+// writeJSONL prints one JSON object per result.
+func writeJSONL(w io.Writer, results []translate.Result, o *options) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, r := range results {
+		j := jsonLine{Line: r.Line + 1, Source: r.Source, Translation: r.Text, Detected: r.Language, Status: r.Status}
+		if o != nil && o.stats {
+			j.LatencyMS = float64(r.Latency) / float64(time.Millisecond)
+		}
+		if o != nil {
+			j.BackTranslation, j.RoundTrip = o.back[r.Line], roundTrip(r, o)
+			j.Line = lineNumber(r.Line, o) + 1
+			if o.showFile {
+				j.File, _ = position(r.Line, o)
+			}
+		}
+		if r.Err != nil {
+			j.Error = r.Err.Error()
+		}
+		if err := enc.Encode(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV returns a writer for a source,translation table
+// separated by comma, with a header row.
+// -show-file adds a file column before them, -changed-only a line one
+// before that, and -verify a round_trip one after.
+// A -delimiter replaces comma.
+func writeCSV(comma rune) func(io.Writer, []translate.Result, *options) error {
+	return func(w io.Writer, results []translate.Result, o *options) error {
+		cw := csv.NewWriter(w)
+		cw.Comma = comma
+		if o.delimiterSet {
+			cw.Comma, _ = utf8.DecodeRuneInString(o.delimiter)
+		}
+		header := []string{"source", "translation"}
+		if o.showFile {
+			header = append([]string{"file"}, header...)
+		}
+		if o.changedOnly {
+			header = append([]string{"line"}, header...)
+		}
+		if o.verify {
+			header = append(header, "round_trip")
+		}
+		cw.Write(header)
+		for _, r := range results {
+			row := []string{r.Source, r.Text}
+			if o.showFile {
+				file, _ := position(r.Line, o)
+				row = append([]string{file}, row...)
+			}
+			if o.changedOnly {
+				row = append([]string{strconv.Itoa(lineNumber(r.Line, o) + 1)}, row...)
+			}
+			if o.verify {
+				row = append(row, roundTrip(r, o))
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+}
+
+// printStats prints a summary of the run to w: how many lines were
+// translated and failed, s's request counts, and how long it all took.
+func printStats(w io.Writer, s translate.Stats, translated, failed int, elapsed time.Duration) {
+	fmt.Fprintf(w, "%d lines translated, %d failed in %v\n", translated, failed, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "%d requests, %d retries, %d without a response\n", s.Requests, s.Retries, s.Failed)
+	codes := make([]int, 0, len(s.Statuses))
+	for code := range s.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %d %s: %d\n", code, http.StatusText(code), s.Statuses[code])
+	}
+	fmt.Fprintf(w, "%d bytes sent, %d received\n", s.BytesSent, s.BytesReceived)
+}
+
+// withoutComments returns results without the -comment-prefix lines.
+func withoutComments(t *translate.Translator, results []translate.Result) []translate.Result {
+	var rs []translate.Result
+	for _, r := range results {
+		if !t.IsComment(r.Source) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// changed returns the results that translated to something other than
+// their source, for -changed-only.
+func changed(results []translate.Result) []translate.Result {
+	var rs []translate.Result
+	for _, r := range results {
+		if r.Err == nil && r.Text != r.Source {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// countFailed returns how many of results failed.
+func countFailed(results []translate.Result) int {
+	return reportErrors(io.Discard, results, nil)
+}
+
+// countExhausted returns how many of results failed because they ran out of retries.
+func countExhausted(results []translate.Result) int {
+	n := 0
+	for _, r := range results {
+		if errors.Is(r.Err, translate.ErrRetriesExhausted) {
+			n++
+		}
+	}
+	return n
+}
+
+// reportErrors prints each failed line to w and returns how many failed.
+// With several input files, o says which file each came from.
+func reportErrors(w io.Writer, results []translate.Result, o *options) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		failed++
+		if o != nil && o.files != nil {
+			file, n := position(r.Line, o)
+			fmt.Fprintf(w, "%s:%d: %v\n", file, n+1, r.Err)
+		} else {
+			fmt.Fprintf(w, "line %d: %v\n", r.Line+1, r.Err)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiles starts a CPU profile to cpuPath, if set, and returns
+// a func that stops it and writes a heap profile to memPath, if set.
+func startProfiles(cpuPath, memPath string, logger *log.Logger) (stop func(), err error) {
+	var cpu *os.File
+	if cpuPath != "" {
+		if cpu, err = os.Create(cpuPath); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				logger.Print(err)
+			}
+		}
+		if memPath == "" {
+			return
+		}
+		f, err := os.Create(memPath)
+		if err != nil {
+			logger.Print(err)
+			return
+		}
+		runtime.GC() // So the profile shows what's still live.
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			logger.Print(err)
+		}
+		if err := f.Close(); err != nil {
+			logger.Print(err)
+		}
+	}, nil
+}
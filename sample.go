@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// sample picks n of lines, or each line with probability frac if n is 0,
+// at random from seed. It returns them in order with the index of each.
+func sample(lines []string, frac float64, n int, seed int64) (picked []string, nums []int) {
+	rnd := rand.New(rand.NewSource(seed))
+	if n > 0 {
+		if n > len(lines) {
+			n = len(lines)
+		}
+		nums = rnd.Perm(len(lines))[:n]
+		sort.Ints(nums)
+	} else {
+		for i := range lines {
+			if rnd.Float64() < frac {
+				nums = append(nums, i)
+			}
+		}
+	}
+	picked = make([]string, len(nums))
+	for i, l := range nums {
+		picked[i] = lines[l]
+	}
+	return picked, nums
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+	"golang.org/x/text/width"
+)
+
+// Widest a -format=table column gets, in terminal cells.
+const maxTableColumn = 40
+
+// writeTable prints each result's source and translation in two columns
+// lined up for a terminal, where CJK characters take two cells.
+// Cells wider than maxTableColumn are cut short with an ellipsis.
+// -changed-only adds a column of line numbers before them.
+func writeTable(w io.Writer, results []translate.Result, o *options) error {
+	rows := [][]string{{"SOURCE", "TRANSLATION"}}
+	if o.changedOnly {
+		rows[0] = append([]string{"LINE"}, rows[0]...)
+	}
+	for _, r := range results {
+		text := r.Text
+		if r.Err != nil {
+			text = "error: " + r.Err.Error()
+		}
+		row := []string{truncate(r.Source, maxTableColumn), truncate(text, maxTableColumn)}
+		if o.changedOnly {
+			row = append([]string{strconv.Itoa(lineNumber(r.Line, o) + 1)}, row...)
+		}
+		rows = append(rows, row)
+	}
+	// Every column but the last is padded to the widest of its cells.
+	widths := make([]int, len(rows[0])-1)
+	for _, row := range rows {
+		for i := range widths {
+			if n := cells(row[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for _, row := range rows {
+		var b strings.Builder
+		for i, n := range widths {
+			b.WriteString(row[i] + strings.Repeat(" ", n-cells(row[i])) + "  ")
+		}
+		b.WriteString(row[len(row)-1] + "\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runeCells returns how many terminal cells r takes.
+func runeCells(r rune) int {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r < ' ' || r == 0x7f:
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// cells returns how many terminal cells s takes.
+func cells(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeCells(r)
+	}
+	return n
+}
+
+// truncate cuts s to at most max cells, ending in an ellipsis if it's cut,
+// with tabs and newlines shown as spaces so the row stays on one line.
+func truncate(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, s)
+	if cells(s) <= max {
+		return s
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		c := runeCells(r)
+		if n+c > max-1 { // Leave a cell for the ellipsis.
+			break
+		}
+		b.WriteRune(r)
+		n += c
+	}
+	b.WriteString("…")
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package translate
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// autoStart is how many workers AutoConcurrency lets work to begin with.
+const autoStart = 4
+
+// autoPool decides how many of the workers may work at once
+// with AutoConcurrency.
+//
+// By Little's law, requests going out at Rate and each taking the
+// latency l keep Rate×l of them in flight, so that's how many workers
+// it takes to keep up with the limiter, and any more only queue behind
+// it. After each request the pool folds its latency into a moving
+// average, weighting the newest by a fifth, and allows Rate times the
+// average plus a quarter for jitter, between 1 and Concurrency.
+// Until the first response it allows autoStart.
+type autoPool struct {
+	rate float64
+	max  int
+	logf func(format string, args ...interface{})
+
+	mu      sync.Mutex
+	allowed int
+	latency time.Duration // The moving average; 0 before the first response.
+	grown   chan struct{} // Closed, and replaced, when allowed goes up.
+	done    bool          // Set by finish; allowed stays at max.
+}
+
+func (t *Translator) newAutoPool() *autoPool {
+	if !t.AutoConcurrency {
+		return nil
+	}
+	p := &autoPool{rate: t.Rate, max: t.Concurrency, logf: t.logf, allowed: autoStart, grown: make(chan struct{})}
+	if p.allowed > p.max {
+		p.allowed = p.max
+	}
+	return p
+}
+
+// wait blocks worker i, from 0, until it's allowed to work or ctx is done.
+// A nil pool allows every worker.
+func (p *autoPool) wait(ctx context.Context, i int) {
+	for p != nil {
+		p.mu.Lock()
+		allowed, grown := p.allowed, p.grown
+		p.mu.Unlock()
+		if i < allowed {
+			return
+		}
+		select {
+		case <-grown:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// observe adjusts the workers allowed after a request that took latency.
+func (p *autoPool) observe(latency time.Duration) {
+	if p == nil || latency <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
+	if p.latency == 0 {
+		p.latency = latency
+	} else {
+		p.latency += (latency - p.latency) / 5
+	}
+	n := int(math.Ceil(p.rate * p.latency.Seconds() * 1.25))
+	if n < 1 {
+		n = 1
+	}
+	if n > p.max {
+		n = p.max
+	}
+	if n != p.allowed {
+		p.logf("concurrency now %d", n)
+		p.set(n)
+	}
+}
+
+// finish lets every worker go, once there's no more work,
+// so the waiting ones see that and stop.
+func (p *autoPool) finish() {
+	if p != nil {
+		p.mu.Lock()
+		p.done = true
+		p.set(p.max)
+		p.mu.Unlock()
+	}
+}
+
+// set allows n workers. p.mu must be held.
+func (p *autoPool) set(n int) {
+	if n > p.allowed {
+		close(p.grown)
+		p.grown = make(chan struct{})
+	}
+	p.allowed = n
+}
+
+// latency returns how long the request for rs took, or 0 if there wasn't one.
+func latency(rs []Result) time.Duration {
+	for _, r := range rs {
+		if r.Latency > 0 {
+			return r.Latency
+		}
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package translate
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestAutoPool(t *testing.T) {
+	tr := New("KEY")
+	tr.AutoConcurrency = true
+	tr.Rate, tr.Concurrency = 10, 16
+	p := tr.newAutoPool()
+	if p.allowed != autoStart {
+		t.Fatalf("started with %d workers, want %d", p.allowed, autoStart)
+	}
+	steps := []struct {
+		latency time.Duration
+		times   int
+		want    int
+	}{
+		{0, 1, autoStart}, // No request.
+		{time.Second, 1, 13},
+		{10 * time.Second, 50, 16}, // No more than Concurrency.
+		{time.Millisecond, 100, 1}, // No fewer than 1.
+	}
+	for i, s := range steps {
+		for j := 0; j < s.times; j++ {
+			p.observe(s.latency)
+		}
+		if p.allowed != s.want {
+			t.Fatalf("step %d: %d workers, want %d", i, p.allowed, s.want)
+		}
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		p.wait(context.Background(), 5)
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("worker 6 worked with 1 allowed")
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.finish()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("finish didn't let worker 6 go")
+	}
+	if p.observe(time.Millisecond); p.allowed != 16 {
+		t.Errorf("%d workers after finish, want all of them", p.allowed)
+	}
+
+	var none *autoPool
+	none.wait(context.Background(), 100) // Returns at once.
+	if tr.AutoConcurrency = false; tr.newAutoPool() != nil {
+		t.Error("got a pool without AutoConcurrency")
+	}
+}
+
+func TestAutoConcurrency(t *testing.T) {
+	s, tr := newStub(t, func(_ int, r *http.Request) (int, string) {
+		time.Sleep(20 * time.Millisecond)
+		return http.StatusOK, prefixed(r)
+	})
+	tr.AutoConcurrency = true
+	tr.Rate, tr.Concurrency, tr.BatchSize = 50, 32, 1
+	lines := make([]string, 40)
+	for i := range lines {
+		lines[i] = fmt.Sprint("行", i)
+	}
+	out, err := tr.Translate(context.Background(), lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[39] != "T:行39" {
+		t.Errorf("got %q", out[39])
+	}
+	if s.maxInFlight > autoStart {
+		t.Errorf("%d requests at once, want at most %d with 20ms at 50/s", s.maxInFlight, autoStart)
+	}
+}
@@ -0,0 +1,166 @@
+package translate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cache is a file of translations kept between runs, each under its
+// languages and the settings it was made with, so that changing any
+// setting that changes translations misses the old ones.
+// It is safe for concurrent use. A nil *Cache holds nothing.
+type Cache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[cacheKey]string
+	dirty   bool
+}
+
+type cacheKey struct {
+	source, target, settings, text string
+}
+
+// On disk the cache is a JSON list of these.
+type cacheJSON struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Settings    string `json:"settings,omitempty"`
+	Text        string `json:"text"`
+	Translation string `json:"translation"`
+}
+
+// OpenCache loads the cache in the file at path.
+// The file needn't exist yet; Save creates it.
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{path: path, entries: make(map[cacheKey]string)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []cacheJSON
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		c.entries[cacheKey{e.Source, e.Target, e.Settings, e.Text}] = e.Translation
+	}
+	return c, nil
+}
+
+// Get returns the cached translation of text from source to target
+// made with settings, a fingerprint like Translator.Settings returns.
+func (c *Cache) Get(source, target, settings, text string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tr, ok := c.entries[cacheKey{source, target, settings, text}]
+	return tr, ok
+}
+
+// Put caches the translation of text from source to target made with settings.
+func (c *Cache) Put(source, target, settings, text, translation string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := cacheKey{source, target, settings, text}
+	if old, ok := c.entries[k]; !ok || old != translation {
+		c.entries[k] = translation
+		c.dirty = true
+	}
+}
+
+// Save writes the cache back to its file if anything changed.
+// The file is replaced atomically, so a crash can't leave it half-written.
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	entries := make([]cacheJSON, 0, len(c.entries))
+	for k, tr := range c.entries {
+		entries = append(entries, cacheJSON{Source: k.source, Target: k.target, Settings: k.settings, Text: k.text, Translation: tr})
+	}
+	// Keep the file stable between runs.
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Settings != b.Settings {
+			return a.Settings < b.Settings
+		}
+		return a.Text < b.Text
+	})
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Settings returns a fingerprint of the fields besides the languages
+// that change what a line translates to: Format, NoUnescape, Params,
+// Glossary and Protect. It is "" while they all have their defaults,
+// and it is what the Cache keeps translations apart by.
+func (t *Translator) Settings() string {
+	var parts []string
+	if t.Format != "" && t.Format != DefaultFormat {
+		parts = append(parts, "format="+t.Format)
+	}
+	if t.NoUnescape {
+		parts = append(parts, "no-unescape")
+	}
+	if len(t.Params) > 0 {
+		parts = append(parts, "params="+t.Params.Encode())
+	}
+	if t.glossary() != nil {
+		parts = append(parts, "glossary="+t.glossarySum)
+	}
+	if t.Protect != nil {
+		parts = append(parts, "protect="+t.Protect.String())
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,188 @@
+package translate
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCacheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	c, err := OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("saving an unchanged cache wrote the file: %v", err)
+	}
+	c.Put("zh-CN", "zh-TW", "", "软件", "軟體")
+	c.Put("zh-CN", "en", "", "软件", "software")
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(path)
+
+	c, err = OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ target, want string }{{"zh-TW", "軟體"}, {"en", "software"}} {
+		if got, ok := c.Get("zh-CN", tt.target, "", "软件"); !ok || got != tt.want {
+			t.Errorf("Get(zh-CN, %s) = %q, %v, want %q", tt.target, got, ok, tt.want)
+		}
+	}
+	if _, ok := c.Get("en", "zh-TW", "", "软件"); ok {
+		t.Error("got a translation from another source language")
+	}
+	c.Put("zh-CN", "zh-TW", "", "软件", "軟體") // Unchanged, so not dirty.
+	c.Put("zh-CN", "ja", "", "软件", "ソフト")
+	c.Put("zh-CN", "ja", "", "软件", "ソフトウェア")
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = OpenCache(path)
+	if got, _ := c.Get("zh-CN", "ja", "", "软件"); got != "ソフトウェア" {
+		t.Errorf("got %q, want the last Put", got)
+	}
+	matches, _ := filepath.Glob(path + ".tmp*")
+	if len(first) == 0 || len(matches) != 0 {
+		t.Errorf("left %v behind", matches)
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	os.WriteFile(path, []byte("{not json"), 0o644)
+	if _, err := OpenCache(path); err == nil {
+		t.Error("opened a corrupt cache")
+	}
+	var c *Cache
+	c.Put("zh-CN", "zh-TW", "", "一", "一")
+	if _, ok := c.Get("zh-CN", "zh-TW", "", "一"); ok || c.Save() != nil {
+		t.Error("a nil *Cache held something")
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, err := OpenCache(filepath.Join(t.TempDir(), "missing", "cache.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("zh-CN", "zh-TW", "", "一", "T:一")
+	tr := New("KEY")
+	tr.Cache = c
+	err = tr.Close()
+	if err == nil {
+		t.Fatal("saved a cache into a missing directory")
+	}
+	if again := tr.Close(); again != err {
+		t.Errorf("second Close returned %v, want %v", again, err)
+	}
+}
+
+func TestTranslateCached(t *testing.T) {
+	tests := []struct {
+		name   string
+		set    func(*Translator)
+		source string // What the results are cached from.
+	}{
+		{"source", nil, "zh-CN"},
+		{"detected", func(t *Translator) { t.DetectInline = true }, "zh-CN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, failingOn("坏", http.StatusBadRequest))
+			if tt.set != nil {
+				tt.set(tr)
+			}
+			path := filepath.Join(t.TempDir(), "cache.json")
+			c, err := OpenCache(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr.Cache = c
+			lines := []string{"一", "坏", "三"}
+			if _, err := tr.TranslateAll(context.Background(), lines); err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := c.Get(tt.source, "zh-TW", "", "一"); !ok || got != "T:一" {
+				t.Errorf("cached %q, %v from %s", got, ok, tt.source)
+			}
+			if _, ok := c.Get(tt.source, "zh-TW", "", "坏"); ok {
+				t.Error("cached a failed line")
+			}
+			if err := tr.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			c, _ = OpenCache(path)
+			s2, tr2 := newStub(t, nil)
+			tr2.Cache = c
+			results, err := tr2.TranslateAll(context.Background(), lines)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resultTexts(results); !reflect.DeepEqual(got, []string{"T:一", "T:坏", "T:三"}) {
+				t.Errorf("got %q", got)
+			}
+			if got := s2.sent(); !reflect.DeepEqual(got, []string{"坏"}) {
+				t.Errorf("sent %q, want only the line that failed before", got)
+			}
+			if s.count() == 0 {
+				t.Error("the first run sent nothing")
+			}
+		})
+	}
+}
+
+func TestCacheSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*Translator)
+	}{
+		{"format", func(t *Translator) { t.Format = "html" }},
+		{"no unescape", func(t *Translator) { t.NoUnescape = true }},
+		{"params", func(t *Translator) { t.Params = url.Values{"model": {"nmt"}} }},
+		{"glossary", func(t *Translator) { t.Glossary = map[string]string{"一": "壹"} }},
+		{"protect", func(t *Translator) { t.Protect = regexp.MustCompile(`\{\w+\}`) }},
+	}
+	if s := New("KEY").Settings(); s != "" {
+		t.Errorf("default settings %q, want none", s)
+	}
+	seen := make(map[string]string)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := OpenCache(filepath.Join(t.TempDir(), "cache.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, tr := newStub(t, nil)
+			tr.Cache = c
+			if _, err := tr.TranslateAll(context.Background(), []string{"一"}); err != nil {
+				t.Fatal(err)
+			}
+			s, tr := newStub(t, nil)
+			tt.set(tr)
+			tr.Cache = c
+			if _, err := tr.TranslateAll(context.Background(), []string{"一"}); err != nil {
+				t.Fatal(err)
+			}
+			if s.count() != 1 {
+				t.Errorf("sent %d requests, want the line translated again with the new setting", s.count())
+			}
+			if other, ok := seen[tr.Settings()]; ok {
+				t.Errorf("same settings as %s", other)
+			}
+			seen[tr.Settings()] = tt.name
+		})
+	}
+}
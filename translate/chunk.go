@@ -0,0 +1,132 @@
+package translate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// Sentences end at one of these, plus any closing quotes or brackets.
+const (
+	sentenceEnds = "。！？!?.;；…\n"
+	closers      = "」』”’\"')）]"
+)
+
+type sentence struct {
+	text string
+	sep  string // Whitespace after text, kept out of the translation.
+}
+
+// sentences splits s after each sentence end.
+func sentences(s string) []sentence {
+	var ss []sentence
+	start := 0
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+		if !strings.ContainsRune(sentenceEnds, r) {
+			continue
+		}
+		for i < len(s) {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if !strings.ContainsRune(closers, r) && !strings.ContainsRune(sentenceEnds, r) {
+				break
+			}
+			i += n
+		}
+		end := i
+		for i < len(s) {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			i += n
+		}
+		ss = append(ss, sentence{s[start:end], s[end:i]})
+		start = i
+	}
+	if start < len(s) {
+		ss = append(ss, sentence{text: s[start:]})
+	}
+	return ss
+}
+
+// chunk splits s into pieces of at most max characters, on sentence
+// boundaries where it can. Joining each piece with the separator after it
+// gives back s.
+func chunk(s string, max int) []sentence {
+	var pieces []sentence
+	var cur sentence
+	for _, sen := range sentences(s) {
+		for utf8.RuneCountInString(sen.text) > max {
+			// No sentence end in sight; cut it where we must.
+			if cur.text != "" {
+				pieces = append(pieces, cur)
+				cur = sentence{}
+			}
+			cut := len(string([]rune(sen.text)[:max]))
+			pieces = append(pieces, sentence{text: sen.text[:cut]})
+			sen.text = sen.text[cut:]
+		}
+		switch {
+		case cur.text == "":
+			cur = sen
+		case utf8.RuneCountInString(cur.text+cur.sep+sen.text) <= max:
+			cur = sentence{cur.text + cur.sep + sen.text, sen.sep}
+		default:
+			pieces = append(pieces, cur)
+			cur = sen
+		}
+	}
+	if cur.text != "" || cur.sep != "" {
+		pieces = append(pieces, cur)
+	}
+	return pieces
+}
+
+// ErrLineTooLong is the error for a line over MaxBytes when MaxChars
+// is 0, so it can't be split. It fails without being sent.
+var ErrLineTooLong = errors.New("translate: line too long")
+
+// pieceChars returns the most characters translateLong sends at once.
+func (t *Translator) pieceChars() int {
+	max := t.MaxChars
+	if t.MaxBytes > 0 && t.MaxBytes/utf8.UTFMax < max {
+		// Short enough in bytes, however many each character takes.
+		max = t.MaxBytes / utf8.UTFMax
+	}
+	return max
+}
+
+// translateLong translates a line longer than MaxChars or MaxBytes
+// a piece at a time and joins the translations back together
+// with the original spacing.
+func (t *Translator) translateLong(ctx context.Context, s sourceText, limiter *limiter) Result {
+	r := Result{Line: s.line, Source: s.text}
+	if t.MaxChars == 0 {
+		r.Err = fmt.Errorf("%w: %d bytes, more than the %d allowed", ErrLineTooLong, len(s.text), t.MaxBytes)
+		return r
+	}
+	var b strings.Builder
+	for _, p := range chunk(s.text, t.pieceChars()) {
+		if strings.TrimSpace(p.text) != "" {
+			pr := t.translateBatch(ctx, []sourceText{{line: s.line, text: p.text}}, limiter)[0]
+			r.Attempts += pr.Attempts
+			if pr.Err != nil {
+				r.Err, r.Status = pr.Err, pr.Status
+				return r
+			}
+			r.Language, r.Status = pr.Language, pr.Status
+			r.Latency += pr.Latency
+			p.text = pr.Text
+		}
+		b.WriteString(p.text)
+		b.WriteString(p.sep)
+	}
+	r.Text = b.String()
+	return r
+}
@@ -0,0 +1,121 @@
+package translate
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+func TestSentences(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []sentence
+	}{
+		{"", nil},
+		{"一句话", []sentence{{"一句话", ""}}},
+		{"第一句。第二句！", []sentence{{"第一句。", ""}, {"第二句！", ""}}},
+		{"Hi. There?  Yes", []sentence{{"Hi.", " "}, {"There?", "  "}, {"Yes", ""}}},
+		{"他说：“好。”然后", []sentence{{"他说：“好。”", ""}, {"然后", ""}}},
+		{"等等……好", []sentence{{"等等……", ""}, {"好", ""}}},
+	}
+	for _, tt := range tests {
+		if got := sentences(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sentences(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want []string // Piece texts.
+	}{
+		{"short", "一句。两句。", 10, []string{"一句。两句。"}},
+		{"on sentences", "一句。两句。三句。", 6, []string{"一句。两句。", "三句。"}},
+		{"spaces kept", "One. Two. Three.", 9, []string{"One. Two.", "Three."}},
+		{"no sentence end", strings.Repeat("字", 10), 4, []string{"字字字字", "字字字字", "字字"}},
+		{"long sentence among short", "短。" + strings.Repeat("长", 7) + "。尾", 4, []string{"短。", "长长长长", "长长长。", "尾"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pieces := chunk(tt.in, tt.max)
+			var texts []string
+			var joined strings.Builder
+			for _, p := range pieces {
+				if n := utf8.RuneCountInString(p.text); n > tt.max {
+					t.Errorf("piece %q is %d characters, over %d", p.text, n, tt.max)
+				}
+				texts = append(texts, p.text)
+				joined.WriteString(p.text + p.sep)
+			}
+			if !reflect.DeepEqual(texts, tt.want) {
+				t.Errorf("got %q, want %q", texts, tt.want)
+			}
+			if joined.String() != tt.in {
+				t.Errorf("joined back to %q", joined.String())
+			}
+		})
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	long := strings.Repeat("这是一句话。", 10)
+	tests := []struct {
+		name     string
+		maxChars int
+		maxBytes int
+		text     string
+		want     string
+		requests int
+		tooLong  bool
+	}{
+		{"split", 12, 0, long + " 尾", strings.Repeat("T:这是一句话。这是一句话。", 5) + " T:尾", 6, false},
+		{"split by bytes", 100, 36, long, strings.Repeat("T:这是一句话。", 10), 10, false},
+		{"not split", 0, 36, long, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, nil)
+			tr.MaxChars, tr.MaxBytes = tt.maxChars, tt.maxBytes
+			results, err := tr.TranslateAll(context.Background(), []string{"短", tt.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[0].Text != "T:短" {
+				t.Errorf("short line came back as %q", results[0].Text)
+			}
+			r := results[1]
+			if tt.tooLong {
+				if !errors.Is(r.Err, ErrLineTooLong) {
+					t.Errorf("got error %v, want ErrLineTooLong", r.Err)
+				}
+				return
+			}
+			if r.Err != nil || r.Text != tt.want {
+				t.Errorf("got %q, %v, want %q", r.Text, r.Err, tt.want)
+			}
+			if got := s.count() - 1; got != tt.requests {
+				t.Errorf("%d requests for the long line, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestLongLineFails(t *testing.T) {
+	_, tr := newStub(t, failingOn("坏", http.StatusBadRequest))
+	tr.MaxChars = 3
+	results, err := tr.TranslateAll(context.Background(), []string{"好。坏。好。"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.Err == nil || r.Text != "" || r.Status != http.StatusBadRequest {
+		t.Errorf("got %+v, want the piece's error and no text", r)
+	}
+}
@@ -0,0 +1,56 @@
+package translate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// glossary returns a replacer that substitutes the Glossary terms,
+// longest first where they overlap, or nil if there's no Glossary.
+func (t *Translator) glossary() *strings.Replacer {
+	if len(t.Glossary) == 0 {
+		return nil
+	}
+	t.glossaryOnce.Do(func() {
+		terms := make([]string, 0, len(t.Glossary))
+		for term := range t.Glossary {
+			if term != "" {
+				terms = append(terms, term)
+			}
+		}
+		// strings.Replacer prefers earlier pairs when matches
+		// start at the same place, so put the longest terms first.
+		sort.Slice(terms, func(i, j int) bool {
+			ni, nj := utf8.RuneCountInString(terms[i]), utf8.RuneCountInString(terms[j])
+			if ni != nj {
+				return ni > nj
+			}
+			return terms[i] < terms[j]
+		})
+		pairs := make([]string, 0, 2*len(terms))
+		for _, term := range terms {
+			pairs = append(pairs, term, t.Glossary[term])
+		}
+		t.replacer = strings.NewReplacer(pairs...)
+		sum := sha256.Sum256([]byte(strings.Join(pairs, "\x00")))
+		t.glossarySum = hex.EncodeToString(sum[:8])
+	})
+	return t.replacer
+}
+
+// substitute puts the Glossary translations in texts
+// in place of their source terms.
+func (t *Translator) substitute(texts []string) []string {
+	r := t.glossary()
+	if r == nil {
+		return texts
+	}
+	out := make([]string, len(texts))
+	for i, s := range texts {
+		out[i] = r.Replace(s)
+	}
+	return out
+}
@@ -0,0 +1,438 @@
+package translate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	DefaultBaseURL = "https://www.googleapis.com/language/translate/v2"
+
+	// Queries longer than this are POSTed rather than put in the URL,
+	// which Google rejects with a 414 once it gets too long.
+	maxGetQuery = 2000
+
+	// How much of a body that won't parse goes in the error.
+	maxSnippet = 200
+)
+
+// Data comes from this nested JSON structure:
+//{"data": {"translations": [{"translatedText": "你覺得緊張嗎？"]}}}
+
+type respJSON struct {
+	Data tJSON `json:"data"`
+}
+
+type tJSON struct {
+	Translations []ttJSON `json:"translations"`
+}
+
+type ttJSON struct {
+	Text     string `json:"translatedText"`
+	Detected string `json:"detectedSourceLanguage"` // When source was left out.
+}
+
+// Detections come back as a list of guesses per text, best first:
+//{"data": {"detections": [[{"language": "zh-CN", "confidence": 0.98}]]}}
+
+type detectJSON struct {
+	Data struct {
+		Detections [][]struct {
+			Language string `json:"language"`
+		} `json:"detections"`
+	} `json:"data"`
+}
+
+// Supported languages come from:
+//{"data": {"languages": [{"language": "zh-TW"}, ...]}}
+
+type langsJSON struct {
+	Data struct {
+		Languages []struct {
+			Language string `json:"language"`
+		} `json:"languages"`
+	} `json:"data"`
+}
+
+// Errors come back as:
+//{"error": {"code": 403, "message": "Daily Limit Exceeded", "errors": [{"reason": "dailyLimitExceeded", ...}]}}
+
+type errorJSON struct {
+	Error eJSON `json:"error"`
+}
+
+type eJSON struct {
+	Code    int      `json:"code"`
+	Message string   `json:"message"`
+	Errors  []eeJSON `json:"errors"`
+}
+
+type eeJSON struct {
+	Domain  string `json:"domain"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// decode parses the JSON body into v. If it won't parse, e.g. because
+// a proxy sent back an HTML page, the error includes the start of body.
+func decode(body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("bad response: %v: %q", err, snippet(body))
+	}
+	return nil
+}
+
+// snippet returns the start of body, cut at a rune boundary.
+func snippet(body []byte) string {
+	s := strings.ToValidUTF8(string(body), "\uFFFD")
+	if len(s) <= maxSnippet {
+		return s
+	}
+	cut := maxSnippet
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// baseURL returns BaseURL or the default for APIVersion.
+func (t *Translator) baseURL() string {
+	switch {
+	case t.BaseURL != "":
+		return strings.TrimSuffix(t.BaseURL, "/")
+	case t.APIVersion == 3:
+		return DefaultV3BaseURL
+	}
+	return DefaultBaseURL
+}
+
+// ReservedParams are the parameters Params can't set.
+var ReservedParams = []string{"q", "source", "target", "key", "format"}
+
+// withParams adds Params to v.
+func (t *Translator) withParams(v url.Values) url.Values {
+	for k, vs := range t.Params {
+		for _, x := range vs {
+			v.Add(k, x)
+		}
+	}
+	return v
+}
+
+// query returns the parameters to translate texts from source to target.
+func (t *Translator) query(texts []string, source, target string) url.Values {
+	v := url.Values{}
+	for _, q := range texts {
+		v.Add("q", q)
+	}
+	v.Set("target", target)
+	if source != "" {
+		v.Set("source", source)
+	}
+	if t.Format != "" {
+		v.Set("format", t.Format)
+	}
+	return v
+}
+
+// newRequest builds a request to endpoint for the query v, as a GET if it's
+// short enough and otherwise as a POST with the query in the body.
+// Cancelling ctx aborts the request.
+func newRequest(ctx context.Context, endpoint string, v url.Values, apiKey string) (*http.Request, error) {
+	key := url.Values{"key": {apiKey}}.Encode()
+	query := v.Encode()
+	if query == "" {
+		return http.NewRequestWithContext(ctx, "GET", endpoint+"?"+key, nil)
+	}
+	if len(query) <= maxGetQuery {
+		return http.NewRequestWithContext(ctx, "GET", endpoint+"?"+query+"&"+key, nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+"?"+key, strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-HTTP-Method-Override", "GET")
+	return req, nil
+}
+
+// redact takes the API key out of the URL the *url.Error err names,
+// since it ends up in logs and output files.
+func redact(err error) error {
+	ue, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+	u, perr := url.Parse(ue.URL)
+	if perr != nil {
+		return &url.Error{Op: ue.Op, URL: "(unparsable URL)", Err: ue.Err}
+	}
+	if q := u.Query(); q.Has("key") {
+		q.Set("key", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return &url.Error{Op: ue.Op, URL: u.String(), Err: ue.Err}
+}
+
+// reply is how a request went, for Result.
+type reply struct {
+	status   int // 0 if there was no response.
+	latency  time.Duration
+	attempts int      // Set by requestRetry, counting retries.
+	detected []string // The language of each text, if the API detected them.
+}
+
+// do sends the query v to endpoint and returns the response body.
+// Error responses come back as an *apiError.
+// If rep isn't nil it's set to how the request went.
+func (t *Translator) do(ctx context.Context, endpoint string, v url.Values, rep *reply) ([]byte, error) {
+	req, err := newRequest(ctx, endpoint, t.withParams(v), t.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	return t.send(req, len(v["q"]), rep)
+}
+
+// setHeader adds Header and the User-Agent to req,
+// without replacing the headers req sets itself.
+func (t *Translator) setHeader(req *http.Request) {
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	for k, vs := range t.Header {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := req.Header[k]; ok && k != "User-Agent" {
+			continue
+		}
+		req.Header[k] = vs
+	}
+}
+
+// send sends req, carrying n lines, and returns the response body.
+// Error responses come back as an *apiError.
+// If rep isn't nil it's set to how the request went.
+func (t *Translator) send(req *http.Request, n int, rep *reply) ([]byte, error) {
+	t.logf("%s %s: %d lines", req.Method, req.URL.Path, n)
+	start := time.Now()
+	if rep != nil {
+		defer func() { rep.latency = time.Since(start) }()
+	}
+	t.setHeader(req)
+	sent := int64(len(req.URL.RawQuery))
+	if t.Gzip && req.Body != nil {
+		if err := gzipBody(req); err != nil {
+			return nil, err
+		}
+	}
+	if req.ContentLength > 0 {
+		sent += req.ContentLength
+	}
+	req, traced := t.traced(req)
+	resp, err := t.client().Do(req)
+	traced()
+	if err != nil {
+		err = redact(err)
+		t.logf("%s failed after %v: %v", req.Method, time.Since(start), err)
+		t.tally(func(s *Stats) { s.Requests++; s.Failed++; s.BytesSent += sent })
+		return nil, err
+	}
+	defer resp.Body.Close()
+	t.logf("%s %s in %v", req.Method, resp.Status, time.Since(start))
+	if rep != nil {
+		rep.status = resp.StatusCode
+	}
+	body, err := io.ReadAll(resp.Body)
+	t.tally(func(s *Stats) {
+		s.Requests++
+		s.Statuses[resp.StatusCode]++
+		s.BytesSent += sent
+		s.BytesReceived += int64(len(body))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		ae := &apiError{status: resp.StatusCode, message: snippet(body), retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		var e errorJSON
+		if err := json.Unmarshal(body, &e); err == nil && e.Error.Message != "" {
+			ae.message = e.Error.Message
+		}
+		if len(e.Error.Errors) > 0 {
+			ae.reason = e.Error.Errors[0].Reason
+		}
+		return nil, ae
+	}
+	return body, nil
+}
+
+// gzipBody compresses the body of req.
+func gzipBody(req *http.Request) error {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := io.Copy(zw, req.Body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(&b)
+	req.ContentLength = int64(b.Len())
+	req.GetBody = nil // Redirects can't resend it.
+	req.Header.Set("Content-Encoding", "gzip")
+	return nil
+}
+
+// Plan returns the requests Translate would send for lines, without
+// sending them and with the API key redacted. Batches are filled up
+// to BatchSize, and split by MaxChars and MaxBytes as they would be,
+// so a real run makes at least this many requests.
+func (t *Translator) Plan(lines []string) ([]*http.Request, error) {
+	todo, _ := t.pending(lines)
+	if t.Dedup {
+		todo = distinct(todo)
+	}
+	var batches [][]sourceText
+	for i := 0; i < len(todo); i += t.BatchSize {
+		j := i + t.BatchSize
+		if j > len(todo) {
+			j = len(todo)
+		}
+		short, long := t.splitLong(todo[i:j])
+		for _, s := range long {
+			if t.MaxChars == 0 {
+				continue // It would fail with ErrLineTooLong.
+			}
+			for _, p := range chunk(s.text, t.pieceChars()) {
+				if strings.TrimSpace(p.text) != "" {
+					batches = append(batches, []sourceText{{line: s.line, text: p.text}})
+				}
+			}
+		}
+		batches = append(batches, t.splitBytes(short)...)
+	}
+	var reqs []*http.Request
+	for _, b := range batches {
+		var req *http.Request
+		var err error
+		texts, _ := t.outgoing(b)
+		if t.APIVersion == 3 {
+			req, err = t.newV3Request(context.Background(), ":translateText", t.v3Query(texts, t.source(), t.Target))
+		} else {
+			req, err = newRequest(context.Background(), t.baseURL(), t.withParams(t.query(texts, t.source(), t.Target)), "REDACTED")
+		}
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// request translates texts from source to target in a single API call.
+// The API keeps them in order.
+func (t *Translator) request(ctx context.Context, texts []string, source, target string, rep *reply) ([]string, error) {
+	if t.APIVersion == 3 {
+		return t.requestV3(ctx, texts, source, target, rep)
+	}
+	body, err := t.do(ctx, t.baseURL(), t.query(texts, source, target), rep)
+	if err != nil {
+		return nil, err
+	}
+	// Parse it.
+	var j respJSON
+	if err := decode(body, &j); err != nil {
+		return nil, err
+	}
+	if len(j.Data.Translations) == 0 {
+		return nil, fmt.Errorf("no translations in response: %q", snippet(body))
+	}
+	// Got some translated text.
+	translations := make([]string, len(j.Data.Translations))
+	for i, tt := range j.Data.Translations {
+		translations[i] = tt.Text
+		if rep != nil {
+			rep.detected = append(rep.detected, tt.Detected)
+		}
+		if !t.NoUnescape && t.Format != "html" {
+			// The API escapes quotes and ampersands as HTML entities.
+			translations[i] = html.UnescapeString(tt.Text)
+		}
+	}
+	return translations, nil
+}
+
+// Languages returns the language codes the API supports.
+func (t *Translator) Languages(ctx context.Context) ([]string, error) {
+	if t.APIVersion == 3 {
+		return t.languagesV3(ctx)
+	}
+	body, err := t.do(ctx, t.baseURL()+"/languages", url.Values{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var j langsJSON
+	if err := decode(body, &j); err != nil {
+		return nil, err
+	}
+	codes := make([]string, len(j.Data.Languages))
+	for i, l := range j.Data.Languages {
+		codes[i] = l.Language
+	}
+	return codes, nil
+}
+
+// CheckLanguages makes sure the API supports Source (unless Detect or DetectInline is set) and Target,
+// with one request, so a bad code fails before any lines are sent.
+func (t *Translator) CheckLanguages(ctx context.Context) error {
+	codes, err := t.Languages(ctx)
+	if err != nil {
+		return fmt.Errorf("translate: listing languages: %v", err)
+	}
+	supported := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		supported[strings.ToLower(c)] = true
+	}
+	check := []string{t.Target}
+	if !t.Detect && !t.DetectInline {
+		check = append(check, t.Source)
+	}
+	for _, l := range check {
+		if !supported[strings.ToLower(l)] {
+			return fmt.Errorf("translate: unsupported language %q; see https://cloud.google.com/translate/docs/languages", l)
+		}
+	}
+	return nil
+}
+
+// detect returns the language of each of texts, in a single API call.
+func (t *Translator) detect(ctx context.Context, texts []string) ([]string, error) {
+	v := url.Values{}
+	for _, q := range texts {
+		v.Add("q", q)
+	}
+	body, err := t.do(ctx, t.baseURL()+"/detect", v, nil)
+	if err != nil {
+		return nil, err
+	}
+	var j detectJSON
+	if err := decode(body, &j); err != nil {
+		return nil, err
+	}
+	langs := make([]string, len(j.Data.Detections))
+	for i, d := range j.Data.Detections {
+		if len(d) > 0 {
+			langs[i] = d[0].Language
+		}
+	}
+	return langs, nil
+}
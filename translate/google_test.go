@@ -0,0 +1,280 @@
+package translate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+func TestNewRequest(t *testing.T) {
+	long := url.Values{"q": {strings.Repeat("长", maxGetQuery)}}
+	tests := []struct {
+		name   string
+		v      url.Values
+		method string
+		query  string // Of the URL.
+		body   string
+	}{
+		{"no query", url.Values{}, "GET", "key=K%26", ""},
+		{"short", url.Values{"q": {"一"}, "target": {"zh-TW"}}, "GET", "q=%E4%B8%80&target=zh-TW&key=K%26", ""},
+		{"long", long, "POST", "key=K%26", long.Encode()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := newRequest(context.Background(), "https://example.com/v2", tt.v, "K&")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != tt.method || req.URL.RawQuery != tt.query {
+				t.Errorf("got %s ?%s, want %s ?%s", req.Method, req.URL.RawQuery, tt.method, tt.query)
+			}
+			var body []byte
+			if req.Body != nil {
+				body, _ = io.ReadAll(req.Body)
+			}
+			if string(body) != tt.body {
+				t.Errorf("got body %.40q, want %.40q", body, tt.body)
+			}
+			if tt.method == "POST" && req.Header.Get("X-HTTP-Method-Override") != "GET" {
+				t.Errorf("POST without X-HTTP-Method-Override: GET")
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	other := errors.New("other")
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&url.Error{Op: "Get", URL: "https://example.com/v2?q=x&key=SECRET", Err: other}, `Get "https://example.com/v2?key=REDACTED&q=x": other`},
+		{&url.Error{Op: "Get", URL: "https://example.com/v2?q=x", Err: other}, `Get "https://example.com/v2?q=x": other`},
+		{&url.Error{Op: "Get", URL: "%zz?key=SECRET", Err: other}, `Get "(unparsable URL)": other`},
+		{other, "other"},
+	}
+	for _, tt := range tests {
+		if got := redact(tt.err).Error(); got != tt.want {
+			t.Errorf("redact(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(*Translator)
+		lines []string
+		want  [][]string // The texts of each request.
+	}{
+		{"batches", nil, []string{"一", "二", "", "三"}, [][]string{{"一", "二"}, {"三"}}},
+		{"dedup", func(t *Translator) { t.Dedup = true }, []string{"一", "一", "二", "一"}, [][]string{{"一", "二"}}},
+		{"long lines", func(t *Translator) { t.MaxChars = 4 }, []string{"一", "第一句。第二句。"}, [][]string{{"第一句。"}, {"第二句。"}, {"一"}}},
+		{"too long", func(t *Translator) { t.MaxChars, t.MaxBytes = 0, 8 }, []string{"一", "第一句。第二句。"}, [][]string{{"一"}}},
+		{"glossary", func(t *Translator) { t.Glossary = map[string]string{"二": "貳"} }, []string{"二"}, [][]string{{"貳"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := New("SECRET")
+			tr.BatchSize = 2
+			if tt.set != nil {
+				tt.set(tr)
+			}
+			reqs, err := tr.Plan(tt.lines)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]string
+			for _, req := range reqs {
+				if strings.Contains(req.URL.String(), "SECRET") {
+					t.Errorf("%s has the key in it", req.URL)
+				}
+				got = append(got, req.URL.Query()["q"])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("页", maxSnippet)
+	tests := []struct{ body, want string }{
+		{"<html>", "<html>"},
+		{"\xff<html>", "\uFFFD<html>"},
+		{long, long[:maxSnippet/3*3] + "..."},
+	}
+	for _, tt := range tests {
+		if got := snippet([]byte(tt.body)); got != tt.want {
+			t.Errorf("snippet(%.20q) = %.20q, want %.20q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		line   string
+		want   http.Header
+	}{
+		{"default", nil, "一", http.Header{"User-Agent": {DefaultUserAgent}}},
+		{"extra", http.Header{"x-goog-user-project": {"p"}}, "一", http.Header{"X-Goog-User-Project": {"p"}, "User-Agent": {DefaultUserAgent}}},
+		{"user agent", http.Header{"User-Agent": {"mine/1.0"}}, "一", http.Header{"User-Agent": {"mine/1.0"}}},
+		{"own content type kept", http.Header{"Content-Type": {"text/plain"}}, strings.Repeat("长", maxGetQuery), http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, nil)
+			tr.Header = tt.header
+			if _, err := tr.Translate(context.Background(), []string{tt.line}); err != nil {
+				t.Fatal(err)
+			}
+			for k, vs := range tt.want {
+				if got := s.requests[0].Header[k]; !reflect.DeepEqual(got, vs) {
+					t.Errorf("%s = %q, want %q", k, got, vs)
+				}
+			}
+		})
+	}
+	if !strings.HasPrefix(DefaultUserAgent, "simp-to-trad-goog-api") {
+		t.Errorf("DefaultUserAgent = %q", DefaultUserAgent)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	s, tr := newStub(t, nil)
+	tr.Gzip = true
+	long := strings.Repeat("长", maxGetQuery)
+	out, err := tr.Translate(context.Background(), []string{"短", long})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0] != "T:短" || out[1] != "T:"+long {
+		t.Errorf("got %.20q", out)
+	}
+	for _, r := range s.requests {
+		gzipped := r.Header.Get("Content-Encoding") == "gzip"
+		if gzipped != (r.Method == "POST") {
+			t.Errorf("%s gzipped: %v, want only POSTs gzipped", r.Method, gzipped)
+		}
+	}
+	if st := tr.Stats(); st.BytesSent >= int64(len(long)) {
+		t.Errorf("sent %d bytes for %d of text, want them counted compressed", st.BytesSent, len(long))
+	}
+}
+
+func TestTrace(t *testing.T) {
+	_, tr := newStub(t, nil)
+	var b bytes.Buffer
+	tr.Trace = log.New(&b, "", 0)
+	tr.Concurrency, tr.BatchSize = 1, 1
+	if _, err := tr.Translate(context.Background(), []string{"一", "二"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got trace %q, want a line per request", lines)
+	}
+	if !strings.HasPrefix(lines[0], "trace GET ") || !strings.Contains(lines[0], "connect ") || !strings.Contains(lines[0], "first byte at") {
+		t.Errorf("first request traced as %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "reused connection") {
+		t.Errorf("second request traced as %q, want its connection reused", lines[1])
+	}
+}
+
+func TestCheckLanguages(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(*Translator)
+		wantErr string
+	}{
+		{"supported", nil, ""},
+		{"case", func(t *Translator) { t.Target = "ZH-tw" }, ""},
+		{"bad target", func(t *Translator) { t.Target = "xx" }, `unsupported language "xx"`},
+		{"bad source", func(t *Translator) { t.Source = "xx" }, `unsupported language "xx"`},
+		{"source not needed", func(t *Translator) { t.Source, t.DetectInline = "xx", true }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, tr := newStub(t, nil)
+			if tt.set != nil {
+				tt.set(tr)
+			}
+			err := tr.CheckLanguages(context.Background())
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	s, tr := newStub(t, nil)
+	s.Close()
+	if err := tr.CheckLanguages(context.Background()); err == nil || !strings.Contains(err.Error(), "listing languages") {
+		t.Errorf("got error %v with the API down", err)
+	}
+}
+
+func TestV3(t *testing.T) {
+	var got []*http.Request
+	var bodies []v3RequestJSON
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r)
+		if strings.HasSuffix(r.URL.Path, "/supportedLanguages") {
+			io.WriteString(w, `{"languages": [{"languageCode": "zh-CN"}, {"languageCode": "zh-TW"}]}`)
+			return
+		}
+		var body v3RequestJSON
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		var resp v3RespJSON
+		for _, c := range body.Contents {
+			resp.Translations = append(resp.Translations, struct {
+				Text     string `json:"translatedText"`
+				Detected string `json:"detectedLanguageCode"`
+			}{Text: "T:" + c + "&amp;"})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+	tr := New("")
+	tr.APIVersion, tr.Project, tr.BaseURL = 3, "proj", srv.URL
+	tr.Client = &http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "TOKEN"}),
+		Base:   srv.Client().Transport,
+	}}
+	if err := tr.CheckLanguages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	out, err := tr.Translate(context.Background(), []string{"一"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"T:一&amp;"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("got %q, want %q, with nothing unescaped", out, want)
+	}
+	if len(got) != 2 || got[0].URL.Path != "/projects/proj/supportedLanguages" || got[1].URL.Path != "/projects/proj:translateText" {
+		t.Fatalf("got %d requests", len(got))
+	}
+	for _, r := range got {
+		if r.Header.Get("Authorization") != "Bearer TOKEN" || r.URL.RawQuery != "" {
+			t.Errorf("%s sent with Authorization %q and query %q", r.URL.Path, r.Header.Get("Authorization"), r.URL.RawQuery)
+		}
+	}
+	want := v3RequestJSON{Contents: []string{"一"}, SourceLanguageCode: "zh-CN", TargetLanguageCode: "zh-TW", MimeType: "text/plain"}
+	if !reflect.DeepEqual(bodies[0], want) {
+		t.Errorf("sent %+v, want %+v", bodies[0], want)
+	}
+}
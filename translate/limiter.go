@@ -0,0 +1,135 @@
+package translate
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// After this many 429s in a row the rate halves,
+// and after this many successes in a row it goes back up by a quarter.
+const (
+	throttleAfter = 3
+	recoverAfter  = 50
+)
+
+// limiter throttles requests to Rate. With a MinRate it adapts:
+// repeated 429s lower the rate towards MinRate and sustained
+// success raises it back towards Rate.
+// With a MaxInFlight it also bounds the requests outstanding at once.
+// Each worker has its own copy, from worker, to pace it to MinInterval.
+type limiter struct {
+	*rate.Limiter
+	floor, ceiling rate.Limit
+	logf           func(format string, args ...interface{})
+	inflight       chan struct{} // A slot per request allowed at once; nil for no limit.
+	pace           *rate.Limiter // This worker's alone; nil for no MinInterval.
+	*streak                      // Shared by every worker.
+}
+
+// streak counts the 429s or successes in a row.
+type streak struct {
+	mu                   sync.Mutex
+	throttled, succeeded int
+}
+
+func (t *Translator) newLimiter() *limiter {
+	burst := t.Burst
+	if burst == 0 {
+		// Let every worker start at once; a burst of 1 would
+		// serialise them and keep throughput well under Rate.
+		burst = t.Concurrency
+	}
+	l := &limiter{
+		Limiter: rate.NewLimiter(rate.Limit(t.Rate), burst),
+		floor:   rate.Limit(t.MinRate),
+		ceiling: rate.Limit(t.Rate),
+		logf:    t.logf,
+		streak:  &streak{},
+	}
+	if t.MaxInFlight > 0 {
+		l.inflight = make(chan struct{}, t.MaxInFlight)
+	}
+	return l
+}
+
+// worker returns a copy of l for one worker that also keeps
+// at least interval between that worker's requests.
+func (l *limiter) worker(interval time.Duration) *limiter {
+	w := *l
+	if interval > 0 {
+		w.pace = rate.NewLimiter(rate.Every(interval), 1)
+	}
+	return &w
+}
+
+// Wait blocks until the worker may send a request.
+func (l *limiter) Wait(ctx context.Context) error {
+	if l.pace != nil {
+		if err := l.pace.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return l.Limiter.Wait(ctx)
+}
+
+// acquire waits for a request slot, until ctx is done.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l.inflight == nil {
+		return nil
+	}
+	select {
+	case l.inflight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release gives back the slot from acquire.
+func (l *limiter) release() {
+	if l.inflight != nil {
+		<-l.inflight
+	}
+}
+
+// observe adjusts the rate after a request that returned err.
+func (l *limiter) observe(err error) {
+	if l.floor == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := err.(*apiError); ok && e.status == http.StatusTooManyRequests {
+		l.succeeded = 0
+		if l.throttled++; l.throttled >= throttleAfter {
+			l.throttled = 0
+			l.set(l.Limit() / 2)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	l.throttled = 0
+	if l.succeeded++; l.succeeded >= recoverAfter {
+		l.succeeded = 0
+		l.set(l.Limit() * 5 / 4)
+	}
+}
+
+func (l *limiter) set(r rate.Limit) {
+	if r < l.floor {
+		r = l.floor
+	}
+	if r > l.ceiling {
+		r = l.ceiling
+	}
+	if r != l.Limit() {
+		l.logf("rate now %.1f/s", float64(r))
+		l.SetLimit(r)
+	}
+}
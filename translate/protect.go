@@ -0,0 +1,51 @@
+package translate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Protected text is sent as one of these private-use characters each,
+// which the API passes through as they are, numbered from placeholder.
+const (
+	placeholder     = '\uE000'
+	maxPlaceholders = '\uF8FF' - placeholder + 1
+)
+
+// protect replaces what Protect matches in each of texts with
+// a placeholder, and returns what each one's placeholders stand for,
+// in order. Matches past maxPlaceholders in a line are sent as they are.
+func (t *Translator) protect(texts []string) (protected [][]string) {
+	if t.Protect == nil {
+		return nil
+	}
+	protected = make([][]string, len(texts))
+	for i, s := range texts {
+		texts[i] = t.Protect.ReplaceAllStringFunc(s, func(m string) string {
+			n := len(protected[i])
+			if n == maxPlaceholders {
+				return m
+			}
+			protected[i] = append(protected[i], m)
+			return string(placeholder + rune(n))
+		})
+	}
+	return protected
+}
+
+// restore puts the protected text back in place of its placeholders
+// in s, wherever the translation moved them, and fails if any are missing.
+func restore(s string, protected []string) (string, error) {
+	if len(protected) == 0 {
+		return s, nil
+	}
+	pairs := make([]string, 0, 2*len(protected))
+	for n, p := range protected {
+		ph := string(placeholder + rune(n))
+		if !strings.Contains(s, ph) {
+			return "", fmt.Errorf("protected %q is missing from the translation", p)
+		}
+		pairs = append(pairs, ph, p)
+	}
+	return strings.NewReplacer(pairs...).Replace(s), nil
+}
@@ -0,0 +1,86 @@
+package translate
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestProtect(t *testing.T) {
+	tests := []struct {
+		name     string
+		glossary map[string]string
+		in       string
+		reply    func(sent string) string // The API's translation of what was sent.
+		want     string
+		wantErr  string
+	}{
+		{
+			name:  "kept",
+			in:    "你好 {name}，有 %d 条消息",
+			reply: func(s string) string { return s },
+			want:  "你好 {name}，有 %d 条消息",
+		},
+		{
+			name: "moved",
+			in:   "{a} 和 {b}",
+			reply: func(s string) string {
+				// The API reorders them and adds an X.
+				return strings.NewReplacer("\uE000", "\uE001", "\uE001", "\uE000X").Replace(s)
+			},
+			want: "{b} 和 {a}X",
+		},
+		{
+			name:     "safe from the glossary",
+			glossary: map[string]string{"name": "名字", "你好": "您好"},
+			in:       "你好 {name}",
+			reply:    func(s string) string { return s },
+			want:     "您好 {name}",
+		},
+		{
+			name:    "dropped",
+			in:      "你好 {name}",
+			reply:   func(s string) string { return strings.Replace(s, "\uE000", "", 1) },
+			wantErr: `protected "{name}" is missing from the translation`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, tr := newStub(t, func(_ int, r *http.Request) (int, string) {
+				return http.StatusOK, translations(tt.reply(r.Form.Get("q")))
+			})
+			tr.Protect = regexp.MustCompile(`\{\w+\}|%d`)
+			tr.Glossary = tt.glossary
+			results, err := tr.TranslateAll(context.Background(), []string{tt.in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := results[0]
+			if tt.wantErr != "" {
+				if r.Err == nil || r.Err.Error() != tt.wantErr {
+					t.Errorf("got error %v, want %q", r.Err, tt.wantErr)
+				}
+				return
+			}
+			if r.Err != nil || r.Text != tt.want {
+				t.Errorf("got %q, %v, want %q", r.Text, r.Err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTooManyPlaceholders(t *testing.T) {
+	tr := New("KEY")
+	tr.Protect = regexp.MustCompile(`x`)
+	texts := []string{strings.Repeat("x", maxPlaceholders+2)}
+	protected := tr.protect(texts)
+	if len(protected[0]) != maxPlaceholders || !strings.HasSuffix(texts[0], "xx") {
+		t.Errorf("protected %d matches, want %d and the rest sent as they are", len(protected[0]), maxPlaceholders)
+	}
+	if s, err := restore(texts[0], protected[0]); err != nil || s != strings.Repeat("x", maxPlaceholders+2) {
+		t.Errorf("restore: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package translate
+
+import "golang.org/x/net/context"
+
+// Provider translates texts from source to target in a single call,
+// returning the translations in the same order. A Translator batches
+// and throttles the calls, but only retries Google's 429 and 5xx errors,
+// so other errors from a Provider fail their lines at once.
+type Provider interface {
+	Translate(ctx context.Context, texts []string, source, target string) ([]string, error)
+}
+
+// Google is the Provider for Google's API, the one the Translator T uses
+// when its Provider is nil. It uses T's APIVersion, key, client and so on.
+// As a Translator's Provider, its lines still get their Result's Status,
+// Latency and detected Language.
+type Google struct {
+	T *Translator
+}
+
+func (g Google) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	return g.T.request(ctx, texts, source, target, nil)
+}
+
+// google returns the Translator whose settings Google's API is called
+// with, or nil if it's another Provider.
+func (t *Translator) google() *Translator {
+	switch p := t.Provider.(type) {
+	case nil:
+		return t
+	case Google:
+		return p.T
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package translate
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	baseBackoff = 500 * time.Millisecond
+	maxBackoff  = 30 * time.Second
+)
+
+// ErrQuotaExceeded is what errors from running out of API quota are.
+// The first one stops Translate and TranslateAll, since every other
+// line would fail the same way.
+var ErrQuotaExceeded = errors.New("translate: API quota exceeded")
+
+// ErrRetriesExhausted is what a *RetriesExhaustedError is, for errors.Is.
+var ErrRetriesExhausted = errors.New("translate: retries exhausted")
+
+// RetriesExhaustedError is a request that kept failing in a way worth
+// retrying, like a 429 or 503, until Retries ran out, as opposed to
+// one that failed for good, like a 400.
+type RetriesExhaustedError struct {
+	Attempts int // Including the first.
+	Status   int // Of the last response, or 0 if there wasn't one.
+	Err      error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetriesExhaustedError) Unwrap() error { return e.Err }
+
+func (e *RetriesExhaustedError) Is(target error) bool { return target == ErrRetriesExhausted }
+
+// apiError is a non-2xx response from the API.
+type apiError struct {
+	status     int
+	message    string
+	reason     string        // Google's reason for the first error, if any.
+	retryAfter time.Duration // From the Retry-After header, if any.
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.status, e.message)
+}
+
+// Is makes errors.Is(err, ErrQuotaExceeded) report quota errors.
+func (e *apiError) Is(target error) bool {
+	return target == ErrQuotaExceeded && (e.reason == "dailyLimitExceeded" || e.reason == "userRateLimitExceeded")
+}
+
+// retryable reports whether err is worth another attempt:
+// rate limiting and server-side failures are, anything else isn't.
+func retryable(err error) bool {
+	e, ok := err.(*apiError)
+	return ok && (e.status == http.StatusTooManyRequests || e.status >= 500)
+}
+
+// backoff returns how long to wait before retry number attempt (from 0),
+// honouring Retry-After when the server sent one, up to maxBackoff.
+func backoff(attempt int, err error) time.Duration {
+	if e, ok := err.(*apiError); ok && e.retryAfter > 0 {
+		if e.retryAfter > maxBackoff {
+			return maxBackoff
+		}
+		return e.retryAfter
+	}
+	d := baseBackoff << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	// Full jitter on the upper half so workers don't retry in lockstep.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// parseRetryAfter understands both forms of the header: seconds or an HTTP date.
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// retry calls f until it succeeds, fails for good, or has been retried
+// t.Retries times, backing off exponentially in between, and logs each
+// attempt with what as the description of f. Every attempt waits for
+// the limiter and holds one of its slots while it runs.
+// Running out of retries returns a *RetriesExhaustedError.
+func (t *Translator) retry(ctx context.Context, limiter *limiter, what string, f func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := limiter.acquire(ctx); err != nil {
+			return err
+		}
+		if err := limiter.Wait(ctx); err != nil {
+			// Cancelled, or the wait would outlast ctx: don't send it.
+			limiter.release()
+			return err
+		}
+		err := f()
+		limiter.release()
+		limiter.observe(err)
+		if !retryable(err) {
+			if err != nil {
+				t.logf("gave up on %s: %v", what, err)
+			}
+			return err
+		}
+		if attempt >= t.Retries {
+			t.logf("gave up on %s: %v", what, err)
+			e := &RetriesExhaustedError{Attempts: attempt + 1, Err: err}
+			if ae, ok := err.(*apiError); ok {
+				e.Status = ae.status
+			}
+			return e
+		}
+		d := backoff(attempt, err)
+		t.logf("retrying %s in %v: %v", what, d, err)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		t.tally(func(s *Stats) { s.Retries++ })
+	}
+}
+
+// requestRetry translates texts from source with retries.
+// rep is how the last attempt went.
+func (t *Translator) requestRetry(ctx context.Context, texts []string, source string, limiter *limiter) (translations []string, rep reply, err error) {
+	attempts := 0
+	defer func() { rep.attempts = attempts }()
+	err = t.retry(ctx, limiter, fmt.Sprintf("%d lines", len(texts)), func() (err error) {
+		attempts++
+		rep = reply{}
+		if g := t.google(); g != nil {
+			translations, err = g.request(ctx, texts, source, t.Target, &rep)
+			return err
+		}
+		start := time.Now()
+		translations, err = t.Provider.Translate(ctx, texts, source, t.Target)
+		rep.latency = time.Since(start)
+		return err
+	})
+	if err == nil {
+		t.logf("translated %d lines", len(texts))
+	}
+	return translations, rep, err
+}
+
+// detectRetry detects the language of texts with retries.
+func (t *Translator) detectRetry(ctx context.Context, texts []string, limiter *limiter) (langs []string, err error) {
+	err = t.retry(ctx, limiter, fmt.Sprintf("detecting %d lines", len(texts)), func() (err error) {
+		langs, err = t.detect(ctx, texts)
+		return err
+	})
+	return langs, err
+}
@@ -0,0 +1,225 @@
+package translate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		statuses []int // Of each attempt; the last repeats.
+		requests int
+		wantErr  bool
+		status   int // Of an exhausted error.
+	}{
+		{"recovers", 3, []int{503, 503, 200}, 3, false, 0},
+		{"exhausted", 1, []int{503}, 2, true, 503},
+		{"rate limited", 1, []int{429}, 2, true, 429},
+		{"not retried", 3, []int{400}, 1, true, 0},
+		{"no retries", 0, []int{503}, 1, true, 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, func(n int, r *http.Request) (int, string) {
+				if n >= len(tt.statuses) {
+					n = len(tt.statuses) - 1
+				}
+				if tt.statuses[n] == http.StatusOK {
+					return http.StatusOK, prefixed(r)
+				}
+				return failing(tt.statuses[n], "")(n, r)
+			})
+			tr.Retries = tt.retries
+			results, err := tr.TranslateAll(context.Background(), []string{"一"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := results[0]
+			if (r.Err != nil) != tt.wantErr {
+				t.Fatalf("got error %v", r.Err)
+			}
+			if n := s.count(); n != tt.requests || r.Attempts != tt.requests {
+				t.Errorf("%d requests, %d attempts, want %d", n, r.Attempts, tt.requests)
+			}
+			if got := tr.Stats().Retries; got != tt.requests-1 {
+				t.Errorf("Stats().Retries = %d, want %d", got, tt.requests-1)
+			}
+			var re *RetriesExhaustedError
+			if exhausted := errors.As(r.Err, &re); exhausted != (tt.status != 0) {
+				t.Fatalf("got error %v, want a *RetriesExhaustedError: %v", r.Err, !exhausted)
+			}
+			if re != nil && (re.Status != tt.status || re.Attempts != tt.requests || !errors.Is(r.Err, ErrRetriesExhausted)) {
+				t.Errorf("got %+v, want status %d after %d attempts", re, tt.status, tt.requests)
+			}
+		})
+	}
+}
+
+func TestLogger(t *testing.T) {
+	_, tr := newStub(t, func(n int, r *http.Request) (int, string) {
+		if n == 0 {
+			return failing(http.StatusServiceUnavailable, "")(n, r)
+		}
+		return http.StatusOK, prefixed(r)
+	})
+	var b bytes.Buffer
+	tr.Logger = log.New(&b, "", 0)
+	tr.Retries = 1
+	if _, err := tr.Translate(context.Background(), []string{"一"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{": 1 lines\n", "GET 503 Service Unavailable in ", "retrying 1 lines in ", "GET 200 OK in ", "translated 1 lines\n"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("logged\n%s\nwant a line with %q", b.String(), want)
+		}
+	}
+}
+
+func TestCancelBackoff(t *testing.T) {
+	_, tr := newStub(t, failing(http.StatusServiceUnavailable, ""))
+	tr.Retries = 5
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := tr.Translate(ctx, []string{"一"}); err == nil {
+		t.Error("got no error")
+	}
+	if d := time.Since(start); d >= baseBackoff/2 {
+		t.Errorf("took %v to stop, want the backoff cut short", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoff(attempt, &apiError{status: 503})
+		most := baseBackoff << uint(attempt)
+		if most > maxBackoff {
+			most = maxBackoff
+		}
+		if d < most/2 || d > most {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, d, most/2, most)
+		}
+	}
+	if d := backoff(0, &apiError{status: 429, retryAfter: 7 * time.Second}); d != 7*time.Second {
+		t.Errorf("backoff with Retry-After 7s = %v", d)
+	}
+	if d := backoff(0, &apiError{status: 429, retryAfter: time.Hour}); d != maxBackoff {
+		t.Errorf("backoff with Retry-After 1h = %v, want %v", d, maxBackoff)
+	}
+	if d := backoff(100, nil); d > maxBackoff {
+		t.Errorf("backoff(100) = %v, over %v", d, maxBackoff)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		h        string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"7", 7 * time.Second, 7 * time.Second},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		if d := parseRetryAfter(tt.h); d < tt.min || d > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.h, d, tt.min, tt.max)
+		}
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	tr := New("KEY")
+	tr.Rate, tr.MinRate = 100, 10
+	l := tr.newLimiter()
+	throttled := &apiError{status: http.StatusTooManyRequests}
+	steps := []struct {
+		err   error
+		times int
+		want  rate.Limit
+	}{
+		{throttled, throttleAfter - 1, 100},
+		{throttled, 1, 50},
+		{&apiError{status: 500}, 5, 50}, // Neither throttled nor a success.
+		{throttled, throttleAfter, 25},
+		{throttled, 4 * throttleAfter, 10}, // No lower than MinRate.
+		{nil, recoverAfter, 12.5},
+		{nil, 100 * recoverAfter, 100}, // No higher than Rate.
+	}
+	for i, s := range steps {
+		for j := 0; j < s.times; j++ {
+			l.observe(s.err)
+		}
+		if got := l.Limit(); got != s.want {
+			t.Fatalf("step %d: rate %v, want %v", i, got, s.want)
+		}
+	}
+}
+
+func TestCancelledWaitSendsNothing(t *testing.T) {
+	tr := New("KEY")
+	tr.Rate, tr.Burst = 0.01, 1
+	l := tr.newLimiter()
+	l.Allow() // Use up the burst, so the next request waits 100s.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := tr.retry(ctx, l, "test", func() error {
+		calls++
+		return nil
+	})
+	if err == nil || calls != 0 {
+		t.Errorf("got error %v after %d calls, want an error without calling", err, calls)
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(*Translator)
+		n     int           // Requests.
+		least time.Duration // From the first to the last.
+		most  time.Duration // 0 for no limit.
+	}{
+		{"rate", func(t *Translator) { t.Rate, t.Burst, t.Concurrency = 20, 1, 4 }, 6, 5 * 50 * time.Millisecond, 0},
+		{"min interval", func(t *Translator) { t.Concurrency, t.MinInterval = 1, 60*time.Millisecond }, 6, 5 * 60 * time.Millisecond, 0},
+		// The burst is the 5 workers, so the other 25 go at the rate.
+		{"default burst", func(t *Translator) { t.Rate, t.Concurrency = 50, 5 }, 30, 25 * 20 * time.Millisecond, 25 * 20 * time.Millisecond * 3 / 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, nil)
+			tr.BatchSize = 1
+			tt.set(tr)
+			lines := make([]string, tt.n)
+			for i := range lines {
+				lines[i] = fmt.Sprint("行", i)
+			}
+			if _, err := tr.Translate(context.Background(), lines); err != nil {
+				t.Fatal(err)
+			}
+			if len(s.times) != tt.n {
+				t.Fatalf("%d requests, want %d", len(s.times), tt.n)
+			}
+			// Leave some slack for the timer.
+			d := s.times[len(s.times)-1].Sub(s.times[0])
+			if d < tt.least*9/10 {
+				t.Errorf("%d requests in %v, want at least %v", tt.n, d, tt.least)
+			}
+			if tt.most > 0 && d > tt.most {
+				t.Errorf("%d requests in %v, want them at the rate, in at most %v", tt.n, d, tt.most)
+			}
+		})
+	}
+}
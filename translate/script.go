@@ -0,0 +1,97 @@
+package translate
+
+// simplifiedOnly holds characters that traditional Chinese writes differently.
+// It comes from simp.txt and trad.txt: every character that differs between
+// a pair of lines and never appears in the traditional text.
+const simplifiedOnly = "万与专业丛东丝丢两严丧个丰临为丽举义乌乐习乡书买乱争于亏云亚产亩亲亿仅仆从仓仪们" +
+	"价众优会伞伟传伤伦伪体佣侥侦侧侨俨俩俭债倾偿储儿兑党兰关兴养兽内冈册写军农冯冲决" +
+	"况冻净凄凉减凑几凤凭击刘则刚创删别刹剂剑剥剧劝办务动励劲劳势勋匀区医华协单卖卧卫" +
+	"却厂厅历厉压厌厕厢厦厨县参双发变叙叠叶号叹吁吓吗吨听启吴呐呕员呛咙响唠唤啬啸喂喷" +
+	"嘘嘱嚣团园囱围国图圆圣场坏块坚坛坝坞坟坠垄垒堕墙壮声壳壶处备够头夸夹夺奋奖奥奸妆" +
+	"妇妈娇娱婴婶孙学宁宝实宠审宪宫宽宾对寻导寿将尔尘尝尴尸尽层届属屡屿岁岂岗岛峡崭巩" +
+	"币帅师帐帘帜带帮并幺广庄庆库应庙庞废开异弃张弥弯弹强归当录彻径御忆忧怀态怂怜总恋" +
+	"恒恳恶恼悦悬悯惊惧惨惩惫惭惯愤愿懒戏战户扑执扩扫扬扰抚抛抢护报担拟拢拣拥拦拨择挂" +
+	"挚挠挡挣挤挥捞损捡换据掷揽搁搂搅携摄摆摇摊撑敌数斩断无旧时显晒晓晕暂术朴机杀杂权" +
+	"条来杨极构枢枣枪枭柜柠栅标栈栋栏树栖样档桥桩梦检楼榄横樱橱檐欢欧残殴毁毕毙气汇汉" +
+	"汤汹沈沟没沦泄泛泪泼泽洁洒浅浆浇浊测济浑浒浓涂涌涛涡润涨渍渐渔渗温湾湿溃滚滞满滥" +
+	"滨滩潇潜灭灯灵灾炉炖点炼烂烛烟烦烧烫热爱爷牵牺状犹独狭狮狱猎猪猫献玛环现琐电画畅" +
+	"疗疯痪瘫瘾皱盐监盖盗盘着睁瞒瞩矶矿码砖础硅硕确碍碱礼祷祸禄离秃种积称税稣稳穷窃窍" +
+	"窜窝竖竞笃笔笼筑筹签简篮类粮紧纠红约级纪纫纬纭纯纱纲纳纵纷纸纹纺纽线练组绅细织终" +
+	"绍经绑结绔绕绘给络绝统继绩绪续绰绳维绵绷绸综绿缉缓缔编缝缠缩缴罂网罗罚罢羡耸耻聋" +
+	"职联聪肃肠肤肴肾肿胀胁胆胜胶脉脏脑脚脱脸腊腾舆舰舱艰艳艺节苍苏苹范荆荐荡荣药莱莲" +
+	"获萝营萧萨葱蒋蓝蔷蔼蕴虑虚虫虽虾蚀蚁蚂蚕蛰蜕蜗蜡衅补衬袜袭装裤见观规视览觉触誉计" +
+	"订认讨让训议讯记讲讳讶许论讼讽设访证评识诈诉诊词译试诗诙诚话诞诡询该详诬语误诱说" +
+	"诵请诺读诽课诿谁调谅谈谊谋谍谎谐谓谚谜谢谣谤谦谨谬谱谴贝负贡财责贤败账货质贩贪贫" +
+	"贬购贮贯贴贵贷贸费贺贼贿赂赃资赈赊赋赌赎赏赔赖赘赚赛赞赠赡赢赵赶趋跃践踊车轨转轮" +
+	"软轰轴轻载轿较辄辅辆辈辉辍辐辑输辖辙辞辩边辽达迁过迈运还这进远违连迟迹适选递逻遗" +
+	"遥邓邮邻郁郑酝酱酿释鉴针钉钓钙钞钟钢钥钦钩钱钻铀铁铃铅铜铝铭铲银铺链销锁锄锅锈锋" +
+	"锐错锡锦键锻镇镑镜长门闪闭问闯闰闲间闷闸闹闻阁阅阐阔队阳阴阵阶际陆陈陕险随隐隶难" +
+	"雳雾静韩韵页顶顷项顺须顽顾顿颁颂预领颇颈颐频颓颗题颜额颠风飘飞饥饪饭饮饰饱饶饺饼" +
+	"饿馁馆马驱驳驶驸驻驾骂骄骇验骑骗骚骤鱼鲁鲍鲜鲤鲨鲸鸟鸡鸣鸦鸭鸽鹅鹉鹦鹰麦黄齐齿龄" +
+	"龙龟"
+
+var simplified = make(map[rune]bool)
+
+// traditionalOnly is the other way round: characters simplified Chinese
+// writes differently, from the same files.
+const traditionalOnly = "丟並乾亂亞佈佔來係倆倉個們倖倫偉側偵偽傑傘備傢傭傳債傷傾僅僑僕僥僱價儀億儉儘償優" +
+	"儲儼兇兌兒內兩冊凍別刪則剎剛剝創劃劇劉劍劑勁動務勝勞勢勳勵勸勻匯區協卻厭厲參叢吳" +
+	"吶員問啟喚喪單嗆嗇嗎嘆嘔嘗嘮嘯噓噴噸嚇嚐嚨嚮嚴囂囑囪國圍園圓圖團執堅報場塊塗塢塵" +
+	"墜墮墳壇壓壘壞壟壩壯壺壽夠夢夾奧奪奮妝姦娛婦媽嬌嬰嬸孫學宮實寧審寫寬寵寶將專尋對" +
+	"導尷屆屍屢層屬岡島峽崗嶄嶼嶽帥師帳帶幟幣幫幹幾庫廁廂廈廚廟廠廢廣廳張強彈彎彙後徑" +
+	"從復徵徹恆恥悅悶惡惱愛態慘慚慣慫慮慶慾憂憊憐憑憤憫憲憶懇應懲懶懷懸懼戀戰戲戶拋捨" +
+	"捲掃掙掛採揀揚換揮損搖搶摟摯撈撐撓撥撫撲撿擁擇擊擋擔據擠擬擱擲擴擺擾攏攔攜攝攤攪" +
+	"攬敗敘敵數斃斬斷於時暈暢暫曆曉曬書會東柵桿條梟棄棗棟棧棲楊業極榮構槍樁樂樑樓標樞" +
+	"樣樸樹橋機橫檔檢檯檸櫃櫥櫻欄權欖欽歐歡歲歷歸殘殺殼毀毆氣氾決沒沖況洩洶涼淒淚淨淪" +
+	"淺減渦測渾湊湧湯準溝溫滅滯滲滸滾滿漁漢漬漲漸漿潑潔潛潤潰澆澤濁濃濕濟濤濫濱瀋瀟瀰" +
+	"灑灘灣災為烏無煉煙煩熱燈燉燒燙營燭爐爛爭爺爾牆牽犧狀狹猶獄獅獎獨獲獵獸獻現瑣瑪環" +
+	"產甦畝畢畫異當疊瘋瘓療癢癥癮癱發皺盜盡監盤盪眾睜瞞瞭矚矽砲碩確碼磚磯礎礙礦祿禍禦" +
+	"禮禱禿稅種稱穀穌積穩穫窩窮竄竅竊競筆節範築篤簡簷簽簾籃籌籠籤籲糧糾紀約紅紉紋納紐" +
+	"純紗紙級紛紜紡紮細紳紹終組結絕絝絡給統絲綁經綜綠綢維綱網綽綿緊緒線緝締編緩緯練縣" +
+	"縫縮縱總績繃織繞繩繪繫繳繼續纏罌罰罵罷羅羨義習聖聞聯聰聲聳職聽聾肅脅脈脫脹腎腦腫" +
+	"腳腸膚膠膽臉臘臟臥臨與興舉舊艙艦艱艷荊莊華菸萊萬葉蒐蒼蓋蓮蔔蔣蔥蕩蕭薔薦薩藍藝藥" +
+	"藹蘇蘊蘋蘭蘿處虛號虧蛻蝕蝦蝸螞蟄蟲蟻蠟蠶衊術衛衝補裝裡製複褲襪襯襲見規視親覺覽觀" +
+	"觸訂計訊討訓託記訝訟訪設許訴診註詐評詞詢試詩詭話該詳詼誇誌認誕誘語誠誣誤誦說誰課" +
+	"誹誼調談諉請諒論諜諧諮諱諷諺諾謀謂謊謎謗謙講謝謠謬謹證識譜譯議譴護譽讀變讓讚豈豎" +
+	"豐豔豬貓貝負財貢貧貨販貪貫責貯貴貶買貸費貼貿賀賂賄資賊賑賒賓賞賠賢賣賦質賬賭賴賺" +
+	"購賽贅贈贊贍贏贓贖趕趙趨跡踐踴蹟躍車軌軍軟軸較載輒輔輕輛輝輟輩輪輯輸輻輿轄轉轍轎" +
+	"轟辦辭辯農迴這連週進遊運過達違遙遞遠適遲遷選遺遼邁還邊邏郵鄉鄧鄭鄰醜醞醫醬釀釁釋" +
+	"釘針釣釦鈔鈕鈣鈴鈾鉅鉛鉤銀銅銘銳銷鋁鋒鋤鋪鋼錄錢錦錫錯錶鍊鍋鍛鍵鍾鎊鎖鎮鏟鏡鏽鐘" +
+	"鐵鑑鑰鑽長門閃閉開閏閒間閘閣閱闆闊闖關闡陝陣陰陳陸陽隊階際隨險隱隸隻雖雙雜雞離難" +
+	"雲電霧靂靈靜鞏鞦韆韓韻響頁頂頃項順須頌預頑頒頓頗領頤頭頸頹頻顆題額顏願顛類顧顯風" +
+	"颱飄飛飢飪飯飲飽飾餃餅養餒餓餘餚館餵饑饒馬馮駁駐駕駙駛駭騎騙騰騷驅驕驗驚驟髒體髮" +
+	"鬆鬍鬥鬧鬱魚魯鮑鮮鯉鯊鯨鳥鳳鳴鴉鴨鴿鵝鵡鷹鸚鹹鹼鹽麗麥麵麼黃點黨齊齒齡龍龐龜"
+
+var traditional = make(map[rune]bool)
+
+func init() {
+	for _, r := range simplifiedOnly {
+		simplified[r] = true
+	}
+	for _, r := range traditionalOnly {
+		traditional[r] = true
+	}
+}
+
+// hasSimplified reports whether s has any character that is only used in
+// simplified Chinese. Lines without one (Latin text, traditional Chinese,
+// or characters both scripts share) come back unchanged from zh-CN to zh-TW.
+func hasSimplified(s string) bool {
+	for _, r := range s {
+		if simplified[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// Scripts reports whether s has characters only simplified Chinese uses,
+// and whether it has ones only traditional Chinese uses. A line with
+// both is usually a sign of dirty data.
+func Scripts(s string) (hasSimp, hasTrad bool) {
+	for _, r := range s {
+		hasSimp = hasSimp || simplified[r]
+		hasTrad = hasTrad || traditional[r]
+	}
+	return hasSimp, hasTrad
+}
@@ -0,0 +1,26 @@
+package translate
+
+import "testing"
+
+func TestScripts(t *testing.T) {
+	tests := []struct {
+		in               string
+		hasSimp, hasTrad bool
+	}{
+		{"", false, false},
+		{"hello, world", false, false},
+		{"中文", false, false}, // The same in both.
+		{"简体字", true, false},
+		{"繁體字", false, true},
+		{"这是繁體", true, true},
+	}
+	for _, tt := range tests {
+		hasSimp, hasTrad := Scripts(tt.in)
+		if hasSimp != tt.hasSimp || hasTrad != tt.hasTrad {
+			t.Errorf("Scripts(%q) = %v, %v, want %v, %v", tt.in, hasSimp, hasTrad, tt.hasSimp, tt.hasTrad)
+		}
+		if got := hasSimplified(tt.in); got != tt.hasSimp {
+			t.Errorf("hasSimplified(%q) = %v", tt.in, got)
+		}
+	}
+}
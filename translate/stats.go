@@ -0,0 +1,33 @@
+package translate
+
+// Stats counts the requests a Translator has made.
+type Stats struct {
+	Requests      int         // Sent, including retries.
+	Retries       int         // Requests that were retries.
+	Failed        int         // Requests that got no response at all.
+	Statuses      map[int]int // Responses by HTTP status code.
+	BytesSent     int64       // Query strings and bodies.
+	BytesReceived int64       // Response bodies.
+}
+
+// Stats returns the counts so far. It's safe to call while translating.
+func (t *Translator) Stats() Stats {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+	s := t.stats
+	s.Statuses = make(map[int]int, len(t.stats.Statuses))
+	for code, n := range t.stats.Statuses {
+		s.Statuses[code] = n
+	}
+	return s
+}
+
+// tally updates the counts with f.
+func (t *Translator) tally(f func(*Stats)) {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+	if t.stats.Statuses == nil {
+		t.stats.Statuses = make(map[int]int)
+	}
+	f(&t.stats)
+}
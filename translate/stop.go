@@ -0,0 +1,47 @@
+package translate
+
+import "errors"
+
+// A Stopper decides when a run should stop because of its failed lines:
+// at the first one with FailFast or once out of quota, since every other
+// line would fail the same way, or at the MaxErrors-th. Translate,
+// TranslateAll and TranslateStream each use one, and so can callers
+// collecting a stream's results, to tell why it stopped.
+type Stopper struct {
+	failFast  bool
+	maxErrors int
+	failures  int
+	err       error
+}
+
+// NewStopper returns a Stopper for FailFast and MaxErrors set as given.
+func NewStopper(failFast bool, maxErrors int) *Stopper {
+	return &Stopper{failFast: failFast, maxErrors: maxErrors}
+}
+
+// Observe records r and reports whether it's the result to stop at.
+// It reports true only once; results after it only count as failures.
+func (s *Stopper) Observe(r Result) bool {
+	if r.Err == nil {
+		return false
+	}
+	s.failures++
+	if s.err != nil {
+		return false
+	}
+	switch {
+	case s.failFast || errors.Is(r.Err, ErrQuotaExceeded):
+		s.err = &LineError{Line: r.Line, Err: r.Err}
+	case s.maxErrors > 0 && s.failures >= s.maxErrors:
+		s.err = ErrTooManyErrors
+	}
+	return s.err != nil
+}
+
+// Err returns why the run stopped: the *LineError of the line it stopped
+// at with FailFast or out of quota, ErrTooManyErrors at MaxErrors, or nil
+// if it hasn't.
+func (s *Stopper) Err() error { return s.err }
+
+// Failures returns how many of the results observed had failed.
+func (s *Stopper) Failures() int { return s.failures }
@@ -0,0 +1,53 @@
+package translate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStopper(t *testing.T) {
+	bad := errors.New("bad")
+	quota := &apiError{status: 403, reason: "dailyLimitExceeded"}
+	tests := []struct {
+		name      string
+		failFast  bool
+		maxErrors int
+		errs      []error // Of each result in turn.
+		stopAt    int     // The result it stops at, or -1.
+		want      error
+	}{
+		{"no failures", false, 0, []error{nil, nil}, -1, nil},
+		{"failures", false, 0, []error{bad, bad, bad}, -1, nil},
+		{"fail fast", true, 0, []error{nil, bad, bad}, 1, &LineError{Line: 1, Err: bad}},
+		{"quota", false, 0, []error{bad, quota, bad}, 1, &LineError{Line: 1, Err: quota}},
+		{"max errors", false, 2, []error{bad, nil, bad, bad}, 2, ErrTooManyErrors},
+		{"quota first", false, 2, []error{quota, bad}, 0, &LineError{Line: 0, Err: quota}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStopper(tt.failFast, tt.maxErrors)
+			stopAt, failures := -1, 0
+			for i, err := range tt.errs {
+				if err != nil {
+					failures++
+				}
+				if s.Observe(Result{Line: i, Err: err}) {
+					if stopAt >= 0 {
+						t.Errorf("stopped at %d and again at %d", stopAt, i)
+					}
+					stopAt = i
+				}
+			}
+			if stopAt != tt.stopAt {
+				t.Errorf("stopped at %d, want %d", stopAt, tt.stopAt)
+			}
+			if got := s.Err(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Err() = %v, want %v", got, tt.want)
+			}
+			if s.Failures() != failures {
+				t.Errorf("Failures() = %d, want %d", s.Failures(), failures)
+			}
+		})
+	}
+}
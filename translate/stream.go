@@ -0,0 +1,82 @@
+package translate
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// TranslateStream translates lines as they arrive and sends each
+// Result as soon as it's ready, in no particular order; Line counts
+// from 0 in arrival order. The returned channel is closed once lines
+// is closed and everything sent has been translated, or once ctx is
+// cancelled. The caller must keep receiving until then.
+// Dedup doesn't apply to a stream.
+// Running out of quota, or reaching MaxErrors, stops it as if ctx were
+// cancelled, after sending the Result that did it; the results in
+// flight still finish.
+func (t *Translator) TranslateStream(ctx context.Context, lines <-chan string) (<-chan Result, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	toTranslate := make(chan sourceText)
+	translated := make(chan Result)
+	var wg sync.WaitGroup
+	t.startWorkers(ctx, toTranslate, translated, &wg)
+	// Feed the workers, answering what we can without them.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(toTranslate)
+		for i := 0; ; i++ {
+			var l string
+			var ok bool
+			select {
+			case l, ok = <-lines:
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				return
+			}
+			s := sourceText{line: i, text: t.normalize(l)}
+			if r, ok := t.answer(s); ok {
+				translated <- r
+				continue
+			}
+			select {
+			case toTranslate <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(translated)
+	}()
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		defer cancel()
+		stop := NewStopper(false, t.MaxErrors)
+		for r := range translated {
+			if ctx.Err() != nil && errors.Is(r.Err, ctx.Err()) {
+				continue
+			}
+			t.remember([]Result{r})
+			t.onResult(r)
+			select {
+			case out <- r:
+			case <-parent.Done():
+			}
+			if stop.Observe(r) {
+				cancel()
+			}
+		}
+	}()
+	return out, nil
+}
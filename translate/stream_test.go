@@ -0,0 +1,90 @@
+package translate
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTranslateStream(t *testing.T) {
+	_, tr := newStub(t, nil)
+	tr.SkipUntranslatable = true
+	lines := []string{"简体", "", "abc", "这是"}
+	want := []string{"T:简体", "", "abc", "T:这是"}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprint("这", i))
+		want = append(want, fmt.Sprint("T:这", i))
+	}
+	results := stream(tr, lines)
+	if len(results) != len(lines) {
+		t.Fatalf("got %d results for %d lines", len(results), len(lines))
+	}
+	for i, r := range results {
+		if r.Line != i || r.Source != lines[i] || r.Text != want[i] || r.Err != nil {
+			t.Errorf("result %d = %+v, want %q", i, r, want[i])
+		}
+	}
+}
+
+func TestTranslateStreamIncremental(t *testing.T) {
+	_, tr := newStub(t, nil)
+	in := make(chan string)
+	out, err := tr.TranslateStream(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each line comes out while in is still open, before the next goes in.
+	for i, l := range []string{"简体", "这是", "这个"} {
+		in <- l
+		select {
+		case r := <-out:
+			if r.Line != i || r.Text != "T:"+l || r.Err != nil {
+				t.Errorf("got %+v, want line %d translated", r, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("line %d didn't come out before the next went in", i)
+		}
+	}
+	close(in)
+	select {
+	case r, ok := <-out:
+		if ok {
+			t.Errorf("got %+v after the last line", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream wasn't closed")
+	}
+}
+
+func TestTranslateStreamCancel(t *testing.T) {
+	_, tr := newStub(t, func(_ int, r *http.Request) (int, string) {
+		<-r.Context().Done()
+		return http.StatusOK, prefixed(r)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan string) // Never closed.
+	out, err := tr.TranslateStream(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in <- "一"
+	cancel()
+	select {
+	case r, ok := <-out:
+		if ok {
+			t.Errorf("got %+v after cancelling", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream wasn't closed")
+	}
+}
+
+func TestTranslateStreamInvalid(t *testing.T) {
+	tr := New("")
+	if _, err := tr.TranslateStream(context.Background(), make(chan string)); err == nil {
+		t.Error("started a stream without a key")
+	}
+}
@@ -0,0 +1,84 @@
+package translate
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phases records when each part of one request happened.
+// The hooks can run on other goroutines, hence the lock.
+type phases struct {
+	mu                     sync.Mutex
+	start                  time.Time
+	dnsStart, dnsDone      time.Time
+	connectStart, connDone time.Time
+	tlsStart, tlsDone      time.Time
+	reused                 bool
+	wrote, firstByte       time.Time
+}
+
+// traced returns req with a ClientTrace attached if Trace is set,
+// and a func that logs the timings to Trace once the request is done.
+func (t *Translator) traced(req *http.Request) (*http.Request, func()) {
+	if t.Trace == nil {
+		return req, func() {}
+	}
+	p := &phases{start: time.Now()}
+	at := func(f func()) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		f()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { at(func() { p.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { at(func() { p.dnsDone = time.Now() }) },
+		ConnectStart: func(string, string) {
+			at(func() {
+				// With several addresses only the first attempt's start counts.
+				if p.connectStart.IsZero() {
+					p.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone:          func(string, string, error) { at(func() { p.connDone = time.Now() }) },
+		TLSHandshakeStart:    func() { at(func() { p.tlsStart = time.Now() }) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { at(func() { p.tlsDone = time.Now() }) },
+		GotConn:              func(c httptrace.GotConnInfo) { at(func() { p.reused = c.Reused }) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { at(func() { p.wrote = time.Now() }) },
+		GotFirstResponseByte: func() { at(func() { p.firstByte = time.Now() }) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return req, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		t.Trace.Printf("trace %s %s: %s", req.Method, req.URL.Path, p)
+	}
+}
+
+// String lists the time each phase took, then when the request went
+// and the response started, since the start.
+func (p *phases) String() string {
+	var parts []string
+	took := func(name string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			parts = append(parts, fmt.Sprintf("%s %v", name, to.Sub(from).Round(time.Microsecond)))
+		}
+	}
+	took("dns", p.dnsStart, p.dnsDone)
+	took("connect", p.connectStart, p.connDone)
+	took("tls", p.tlsStart, p.tlsDone)
+	if p.reused {
+		parts = append(parts, "reused connection")
+	}
+	took("wrote request at", p.start, p.wrote)
+	took("first byte at", p.start, p.firstByte)
+	if len(parts) == 0 {
+		return "no connection"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,810 @@
+// Package translate translates lines of text concurrently
+// using the Google Translate API, keeping them in order.
+package translate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	DefaultSource = "zh-CN"
+	DefaultTarget = "zh-TW"
+
+	DefaultRate        = 100 // 100/s is the maximum rate limit, specified by Google
+	DefaultConcurrency = 20  // 20 concurrent network requests.
+	DefaultRetries     = 3
+	DefaultBatchSize   = 50               // Lines sent in one request.
+	DefaultTimeout     = 30 * time.Second // Per HTTP request.
+	DefaultFormat      = "text"
+	DefaultMaxChars    = 5000 // Longest line sent in one piece.
+
+	// Most bytes of text in one request. URL-encoded, that much
+	// Chinese stays within the API's 204,800-byte request limit.
+	DefaultMaxBytes = 64 << 10
+)
+
+// Translator translates lines from Source to Target.
+// Create one with New; the fields may be changed before calling Translate.
+type Translator struct {
+	APIKey      string
+	APIVersion  int         // 2 (the default, with APIKey) or 3 (with Project).
+	Project     string      // Google Cloud project the v3 API bills.
+	BaseURL     string      // Where to send requests; empty means the API version's default.
+	Params      url.Values  // Extra v2 query parameters, like model, for every request; not ReservedParams.
+	Header      http.Header // Extra headers for every request; a User-Agent replaces DefaultUserAgent.
+	Gzip        bool        // Compress the bodies of POSTed requests.
+	Source      string
+	Target      string
+	Format      string  // How the API reads the text: "text" or "html".
+	Concurrency int     // Number of concurrent workers; the most there can be with AutoConcurrency.
+	MaxInFlight int     // Requests outstanding at once, across workers; 0 means Concurrency.
+	Rate        float64 // Maximum requests per second.
+	Burst       int     // Requests allowed at once above Rate; 0 means Concurrency.
+	MinRate     float64 // If set, the rate drops as low as this on repeated 429s.
+	Retries     int     // Retries per request on 429 and 5xx responses.
+	BatchSize   int     // Maximum lines per request.
+	MaxChars    int     // Longer lines are split into sentences; 0 means never.
+	MaxBytes    int     // Most UTF-8 bytes of text per request; 0 means no limit. See ErrLineTooLong.
+	Dedup       bool    // Translate each distinct line only once.
+	Cache       *Cache  // Translations to reuse instead of calling the API; may be nil.
+
+	// Provider does the translating; nil means Google's API, as set up
+	// by the fields above and Client. Detect, CheckLanguages and Plan
+	// always use Google's.
+	Provider Provider
+
+	// Client sends every request; nil means http.DefaultClient.
+	// For the v3 API it has to add credentials; see NewServiceAccountClient.
+	Client *http.Client
+
+	// AutoConcurrency, if set, varies how many of the workers send
+	// requests with their latency, to as many as keep up with Rate.
+	AutoConcurrency bool
+
+	// MinInterval, if set, keeps each worker's requests at least this far
+	// apart, on top of Rate, to smooth out bursts.
+	MinInterval time.Duration
+
+	// RequestTimeout bounds each request, with its retries and backoff,
+	// so a line that hangs fails without holding up the rest. 0 means none.
+	RequestTimeout time.Duration
+
+	// SkipUntranslatable passes lines without simplified characters
+	// through unchanged. Only useful when translating from simplified Chinese.
+	SkipUntranslatable bool
+
+	// CommentPrefix, if set, marks comment lines, which start with it
+	// after any leading space. They are passed through unchanged.
+	CommentPrefix string
+
+	// FailFast stops Translate and TranslateAll at the first line that
+	// fails, after its retries, returning that line's *LineError. Lines
+	// in flight are abandoned. It doesn't apply to TranslateStream.
+	FailFast bool
+
+	// MaxErrors, if set, stops Translate and TranslateAll once this many
+	// lines have failed, after their retries, returning the results so
+	// far and ErrTooManyErrors. Lines in flight are abandoned.
+	// It stops TranslateStream too, after sending the last of them.
+	MaxErrors int
+
+	// Trim sends lines without their leading and trailing space,
+	// which can change how the API translates them, and then puts
+	// the same space around the translations. Lines of only space
+	// are left blank as always.
+	Trim bool
+
+	// Normalize puts lines in Unicode NFC before anything else, so that
+	// lines differing only in how characters are composed are cached,
+	// deduplicated and translated as the same line. Result.Source is
+	// the normalized line.
+	Normalize bool
+
+	// Detect asks the API for each line's language
+	// and translates from that instead of Source.
+	Detect bool
+
+	// DetectInline leaves Source out of translate requests so the API
+	// detects each line's language as it translates, giving it in
+	// Result.Language. Unlike Detect it takes no extra requests.
+	// A Provider gets an empty source.
+	DetectInline bool
+
+	// NoUnescape keeps the HTML entities, like &#39;, that the API
+	// puts in translations instead of decoding them.
+	// Translations are never decoded when Format is "html".
+	NoUnescape bool
+
+	// Progress, if set, is called with the number of lines done
+	// out of the total as each one finishes. TranslateStream doesn't
+	// call it, not knowing the total.
+	Progress func(done, total int)
+
+	// OnResult, if set, is called with each line's Result as soon as
+	// it's done, in no particular order. Calls are one at a time, all on
+	// the goroutine that collects results (the caller's, for Translate
+	// and TranslateAll, which start with the lines answered without the
+	// API), so it needs no locking but holds up collecting while it runs.
+	// Lines abandoned because ctx was cancelled don't get a call.
+	OnResult func(Result)
+
+	// Logger, if set, gets a line for every request, response and retry.
+	// A log.Logger serialises output from the concurrent workers.
+	Logger *log.Logger
+
+	// Warnings, if set, gets a line for every response that was used
+	// but didn't look right, like one with more translations than lines.
+	Warnings *log.Logger
+
+	// Trace, if set, gets a line for every request with how long its
+	// DNS lookup, connect and TLS handshake took, and when the request
+	// was written and the first byte of the response came back.
+	Trace *log.Logger
+
+	// Glossary maps source terms to the translation they must always get.
+	// The translations are put in place of the terms before the text
+	// is sent, longest term first where they overlap, so the API only
+	// sees them already translated. Once in use it mustn't change,
+	// other than to nil and back.
+	Glossary map[string]string
+
+	// Protect, if set, matches text to keep out of the translation, like
+	// the placeholders {name}, %s or {{count}} in UI strings. Each match
+	// is sent as a placeholder the API leaves alone, and put back in its
+	// place, wherever that ends up, in the translation, untouched by
+	// the Glossary. A line whose translation loses one fails.
+	Protect *regexp.Regexp
+
+	glossaryOnce sync.Once
+	replacer     *strings.Replacer
+
+	glossarySum string // Of the Glossary, for Settings; set with replacer.
+
+	statsMu sync.Mutex
+	stats   Stats
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// New returns a Translator from simplified to traditional Chinese
+// with the default limits.
+func New(apiKey string) *Translator {
+	return &Translator{
+		APIKey:      apiKey,
+		Source:      DefaultSource,
+		Target:      DefaultTarget,
+		Format:      DefaultFormat,
+		Concurrency: DefaultConcurrency,
+		Rate:        DefaultRate,
+		Retries:     DefaultRetries,
+		BatchSize:   DefaultBatchSize,
+		MaxChars:    DefaultMaxChars,
+		MaxBytes:    DefaultMaxBytes,
+		Client:      NewClient(DefaultConcurrency, DefaultTimeout),
+	}
+}
+
+// Close saves the Cache and closes the Client's idle connections.
+// Calling it again does nothing and returns the same error.
+func (t *Translator) Close() error {
+	t.closeOnce.Do(func() {
+		t.closeErr = t.Cache.Save()
+		t.client().CloseIdleConnections()
+	})
+	return t.closeErr
+}
+
+// NewClient returns an HTTP client for maxConns concurrent workers
+// that all share one pool of kept-alive connections.
+// It goes through the proxy in HTTP_PROXY or HTTPS_PROXY, if set.
+func NewClient(maxConns int, timeout time.Duration) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = http.ProxyFromEnvironment
+	tr.MaxIdleConns = maxConns
+	tr.MaxIdleConnsPerHost = maxConns
+	tr.DisableKeepAlives = false
+	// Responses are gzipped as long as nothing sets Accept-Encoding
+	// itself, since then the transport asks for gzip and decodes it.
+	tr.DisableCompression = false
+	return &http.Client{Transport: tr, Timeout: timeout}
+}
+
+// LineError is a failure to translate one line.
+type LineError struct {
+	Line int // From 0.
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line+1, e.Err)
+}
+
+func (e *LineError) Unwrap() error { return e.Err }
+
+// Errors lists every line that failed in a call to Translate, by line.
+type Errors []*LineError
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d lines failed; first: %v", len(e), e[0])
+}
+
+type sourceText struct {
+	line int
+	text string
+}
+
+// Result is the outcome of translating one line.
+type Result struct {
+	Line   int // From 0.
+	Source string
+	Text   string
+	Err    error
+
+	Language string // Detected source language, when Translator.Detect or DetectInline is set.
+
+	// The HTTP status of the request that translated the line and how
+	// long it took, for the last attempt. Both are 0 for lines answered
+	// without the API.
+	Status  int
+	Latency time.Duration
+
+	// Attempts is how many requests were made for the line, retries
+	// included; 0 for lines answered without the API.
+	Attempts int
+}
+
+type byLine []Result
+
+func (a byLine) Len() int           { return len(a) }
+func (a byLine) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byLine) Less(i, j int) bool { return a[i].Line < a[j].Line }
+
+func (t *Translator) client() *http.Client {
+	if t.Client == nil {
+		return http.DefaultClient
+	}
+	return t.Client
+}
+
+func (t *Translator) validate() error {
+	switch {
+	case t.APIVersion != 0 && t.APIVersion != 2 && t.APIVersion != 3:
+		return fmt.Errorf("translate: API version must be 2 or 3")
+	case t.APIVersion == 3 && t.Project == "":
+		return fmt.Errorf("translate: the v3 API needs a project")
+	case t.APIVersion == 3 && t.Detect:
+		return fmt.Errorf("translate: detecting languages needs the v2 API")
+	case t.Detect && t.DetectInline:
+		return fmt.Errorf("translate: use Detect or DetectInline, not both")
+	case t.google() != nil && t.google().APIVersion != 3 && t.google().APIKey == "":
+		return fmt.Errorf("translate: no API key")
+	case t.Source == "" || t.Target == "":
+		return fmt.Errorf("translate: source and target must not be empty")
+	case t.Format != "" && t.Format != "text" && t.Format != "html":
+		return fmt.Errorf("translate: format must be text or html")
+	case t.Concurrency <= 0:
+		return fmt.Errorf("translate: concurrency must be positive")
+	case t.MinInterval < 0:
+		return fmt.Errorf("translate: min interval must not be negative")
+	case t.MaxInFlight < 0:
+		return fmt.Errorf("translate: max in flight must not be negative")
+	case t.Rate <= 0:
+		return fmt.Errorf("translate: rate must be positive")
+	case t.MinRate < 0 || t.MinRate > t.Rate:
+		return fmt.Errorf("translate: min rate must be between 0 and rate")
+	case t.Burst < 0:
+		return fmt.Errorf("translate: burst must not be negative")
+	case t.MaxChars < 0:
+		return fmt.Errorf("translate: max chars must not be negative")
+	case t.MaxBytes < 0 || t.MaxBytes > 0 && t.MaxBytes < utf8.UTFMax:
+		return fmt.Errorf("translate: max bytes must be 0 or at least %d", utf8.UTFMax)
+	case t.BatchSize <= 0:
+		return fmt.Errorf("translate: batch size must be positive")
+	case len(t.Params) > 0 && t.APIVersion == 3:
+		return fmt.Errorf("translate: params are only for the v2 API")
+	}
+	for _, k := range ReservedParams {
+		if _, ok := t.Params[k]; ok {
+			return fmt.Errorf("translate: params can't set %q", k)
+		}
+	}
+	return nil
+}
+
+// batches groups lines from from into batches of up to n.
+// A batch is handed over as soon as a worker is free to take it,
+// so batches only fill up while every worker is busy.
+func batches(from <-chan sourceText, n int) <-chan []sourceText {
+	out := make(chan []sourceText)
+	go func() {
+		defer close(out)
+		var pending []sourceText
+		for from != nil || len(pending) > 0 {
+			if len(pending) >= n {
+				out <- pending
+				pending = nil
+				continue
+			}
+			var send chan<- []sourceText
+			if len(pending) > 0 {
+				send = out
+			}
+			select {
+			case s, ok := <-from:
+				if !ok {
+					from = nil
+					continue
+				}
+				pending = append(pending, s)
+			case send <- pending:
+				pending = nil
+			}
+		}
+	}()
+	return out
+}
+
+func texts(b []sourceText) []string {
+	texts := make([]string, len(b))
+	for i, s := range b {
+		texts[i] = s.text
+	}
+	return texts
+}
+
+// failed returns a result with err for each line of b.
+func failed(b []sourceText, err error) []Result {
+	rs := make([]Result, len(b))
+	for i, s := range b {
+		rs[i] = Result{Line: s.line, Source: s.text, Err: err}
+	}
+	return rs
+}
+
+// translateBatch translates a batch of lines
+// and returns a result for each of them.
+func (t *Translator) translateBatch(ctx context.Context, b []sourceText, limiter *limiter) []Result {
+	if !t.Detect {
+		return t.translateFrom(ctx, b, t.source(), limiter)
+	}
+	langs, err := t.detectRetry(ctx, texts(b), limiter)
+	if err != nil {
+		return failed(b, err)
+	}
+	// One request per detected language, in order of first appearance.
+	var order []string
+	groups := make(map[string][]sourceText)
+	for i, s := range b {
+		lang := ""
+		if i < len(langs) {
+			lang = langs[i]
+		}
+		if _, ok := groups[lang]; !ok {
+			order = append(order, lang)
+		}
+		groups[lang] = append(groups[lang], s)
+	}
+	var rs []Result
+	for _, lang := range order {
+		if lang == "" {
+			rs = append(rs, failed(groups[lang], fmt.Errorf("language not detected"))...)
+			continue
+		}
+		for _, r := range t.translateFrom(ctx, groups[lang], lang, limiter) {
+			r.Language = lang
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// source returns the source language to ask for, or "" to have the API detect it.
+func (t *Translator) source() string {
+	if t.DetectInline {
+		return ""
+	}
+	return t.Source
+}
+
+// outgoing returns the text of b as it's sent to the API,
+// and what its Protect placeholders stand for. The Glossary
+// applies only outside the placeholders.
+func (t *Translator) outgoing(b []sourceText) (out []string, protected [][]string) {
+	out = texts(b)
+	protected = t.protect(out)
+	out = t.substitute(out)
+	if t.Trim {
+		for i, s := range out {
+			out[i] = strings.TrimSpace(s)
+		}
+	}
+	return out, protected
+}
+
+// repad puts the space that Trim took from around source
+// back around its translation.
+func (t *Translator) repad(source, translation string) string {
+	if !t.Trim {
+		return translation
+	}
+	lead := source[:len(source)-len(strings.TrimLeftFunc(source, unicode.IsSpace))]
+	trail := source[len(strings.TrimRightFunc(source, unicode.IsSpace)):]
+	return lead + translation + trail
+}
+
+// translateFrom translates a batch of lines from source in one request
+// and returns a result for each of them.
+func (t *Translator) translateFrom(ctx context.Context, b []sourceText, source string, limiter *limiter) []Result {
+	rctx := ctx
+	if t.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithTimeout(ctx, t.RequestTimeout)
+		defer cancel()
+	}
+	texts, protected := t.outgoing(b)
+	translations, rep, err := t.requestRetry(rctx, texts, source, limiter)
+	if err != nil && ctx.Err() == nil && rctx.Err() != nil {
+		err = fmt.Errorf("timed out after %v: %w", t.RequestTimeout, err)
+	}
+	if e, ok := err.(*apiError); ok && e.status == http.StatusBadRequest && len(b) > 1 {
+		// One bad line rejects the whole batch, so find it by going line by line.
+		var rs []Result
+		for i := range b {
+			rs = append(rs, t.translateFrom(ctx, b[i:i+1], source, limiter)...)
+		}
+		return rs
+	}
+	if err == nil && len(translations) > len(b) {
+		t.warnf("got %d translations for a batch of %d; ignoring the extras", len(translations), len(b))
+	}
+	rs := make([]Result, len(b))
+	for i, s := range b {
+		rs[i].Line, rs[i].Source = s.line, s.text
+		rs[i].Status, rs[i].Latency, rs[i].Attempts = rep.status, rep.latency, rep.attempts
+		switch {
+		case err != nil:
+			rs[i].Err = err
+		case i < len(translations):
+			text := translations[i]
+			if protected != nil {
+				if text, rs[i].Err = restore(text, protected[i]); rs[i].Err != nil {
+					break
+				}
+			}
+			rs[i].Text = t.repad(s.text, text)
+			if i < len(rep.detected) && rep.detected[i] != "" {
+				rs[i].Language = rep.detected[i]
+			}
+		default:
+			rs[i].Err = fmt.Errorf("got %d translations for a batch of %d", len(translations), len(b))
+		}
+	}
+	return rs
+}
+
+// splitLong takes the lines longer than MaxChars or MaxBytes out of b.
+func (t *Translator) splitLong(b []sourceText) (short, long []sourceText) {
+	if t.MaxChars == 0 && t.MaxBytes == 0 {
+		return b, nil
+	}
+	for _, s := range b {
+		if t.MaxChars > 0 && utf8.RuneCountInString(s.text) > t.MaxChars || t.MaxBytes > 0 && len(s.text) > t.MaxBytes {
+			long = append(long, s)
+		} else {
+			short = append(short, s)
+		}
+	}
+	return short, long
+}
+
+// splitBytes splits b into batches of at most MaxBytes of text each,
+// keeping the lines in order. No line is longer than MaxBytes itself.
+func (t *Translator) splitBytes(b []sourceText) [][]sourceText {
+	var bs [][]sourceText
+	size := 0
+	for i, s := range b {
+		if i == 0 || t.MaxBytes > 0 && size+len(s.text) > t.MaxBytes {
+			bs = append(bs, nil)
+			size = 0
+		}
+		bs[len(bs)-1] = append(bs[len(bs)-1], s)
+		size += len(s.text)
+	}
+	return bs
+}
+
+func (t *Translator) startWorkers(ctx context.Context, from <-chan sourceText, to chan Result, wg *sync.WaitGroup) {
+	limiter := t.newLimiter()
+	pool := t.newAutoPool()
+	jobs := batches(from, t.BatchSize)
+	for i := 0; i < t.Concurrency; i++ { // Start workers
+		wg.Add(1)
+		limiter := limiter.worker(t.MinInterval)
+		go func(i int) {
+			defer wg.Done()
+			defer pool.finish()
+			for {
+				pool.wait(ctx, i)
+				b, ok := <-jobs
+				if !ok {
+					return
+				}
+				if ctx.Err() != nil {
+					// Cancelled: don't start anything new,
+					// just account for the lines already queued.
+					for _, r := range failed(b, ctx.Err()) {
+						to <- r
+					}
+					continue
+				}
+				b, long := t.splitLong(b)
+				for _, s := range long {
+					to <- t.translateLong(ctx, s, limiter)
+				}
+				// Throttle & perform requests.
+				for _, b := range t.splitBytes(b) {
+					rs := t.translateBatch(ctx, b, limiter)
+					pool.observe(latency(rs))
+					for _, r := range rs {
+						to <- r
+					}
+				}
+			}
+		}(i)
+	}
+}
+
+// Translate translates lines and returns the translations in the same order.
+// If some lines fail, their translations are empty and the error is an Errors.
+func (t *Translator) Translate(ctx context.Context, lines []string) ([]string, error) {
+	results, err := t.TranslateAll(ctx, lines)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(results))
+	var errs Errors
+	for i, r := range results {
+		out[i] = r.Text
+		if r.Err != nil {
+			errs = append(errs, &LineError{Line: r.Line, Err: r.Err})
+		}
+	}
+	if errs != nil {
+		return out, errs
+	}
+	return out, nil
+}
+
+// TranslateAll translates lines and returns a Result for each, in order.
+// Failed lines are reported on their Result; the error is only
+// for a Translator that can't run at all, for ctx being cancelled,
+// for the first failure with FailFast or from running out of quota,
+// or for reaching MaxErrors.
+// Once ctx is cancelled no new requests are made, in-flight ones are
+// aborted, and only the lines that finished beforehand are returned.
+func (t *Translator) TranslateAll(ctx context.Context, lines []string) ([]Result, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	todo, done := t.pending(lines)
+	for _, r := range done {
+		t.onResult(r)
+	}
+	n := len(done)
+	t.progress(n, len(lines))
+	each := func(r Result) {
+		n++
+		t.progress(n, len(lines))
+		t.onResult(r)
+	}
+	var results []Result
+	var err error
+	if t.Dedup {
+		results, err = t.dedup(ctx, todo, each)
+	} else {
+		results, err = t.run(ctx, todo, each)
+	}
+	t.remember(results)
+	results = append(done, results...)
+	sort.Sort(byLine(results))
+	return results, err
+}
+
+func (t *Translator) logf(format string, args ...interface{}) {
+	if t.Logger != nil {
+		t.Logger.Printf(format, args...)
+	}
+}
+
+func (t *Translator) warnf(format string, args ...interface{}) {
+	if t.Warnings != nil {
+		t.Warnings.Printf("warning: "+format, args...)
+	}
+}
+
+func (t *Translator) progress(done, total int) {
+	if t.Progress != nil {
+		t.Progress(done, total)
+	}
+}
+
+func (t *Translator) onResult(r Result) {
+	if t.OnResult != nil {
+		t.OnResult(r)
+	}
+}
+
+// pending splits lines into those that need the API
+// and results for those that can be answered without it.
+func (t *Translator) pending(lines []string) (todo []sourceText, done []Result) {
+	for i, l := range lines {
+		s := sourceText{line: i, text: t.normalize(l)}
+		if r, ok := t.answer(s); ok {
+			done = append(done, r)
+			continue
+		}
+		todo = append(todo, s)
+	}
+	return todo, done
+}
+
+// normalize returns s in NFC if Normalize is set.
+func (t *Translator) normalize(s string) string {
+	if t.Normalize {
+		return norm.NFC.String(s)
+	}
+	return s
+}
+
+// answer returns the result for s if it doesn't need the API.
+func (t *Translator) answer(s sourceText) (Result, bool) {
+	r := Result{Line: s.line, Source: s.text}
+	if strings.TrimSpace(s.text) == "" {
+		// Nothing to translate, and the API may mangle it.
+		return r, true
+	}
+	if t.SkipUntranslatable && !hasSimplified(s.text) || t.IsComment(s.text) {
+		r.Text = s.text
+		return r, true
+	}
+	if t.Detect || t.DetectInline {
+		// The cache is by source language, which isn't known yet.
+		return r, false
+	}
+	if text, ok := t.Cache.Get(t.Source, t.Target, t.Settings(), s.text); ok {
+		r.Text = text
+		return r, true
+	}
+	return r, false
+}
+
+// IsComment reports whether line is a comment by CommentPrefix.
+func (t *Translator) IsComment(line string) bool {
+	return t.CommentPrefix != "" && strings.HasPrefix(strings.TrimLeftFunc(line, unicode.IsSpace), t.CommentPrefix)
+}
+
+// remember caches the successful results, under the language
+// they were detected in if that's how it was found.
+func (t *Translator) remember(results []Result) {
+	for _, r := range results {
+		source := t.Source
+		if t.Detect || t.DetectInline {
+			source = r.Language
+		}
+		if r.Err == nil && source != "" {
+			t.Cache.Put(source, t.Target, t.Settings(), r.Source, r.Text)
+		}
+	}
+}
+
+// distinct returns texts without repeated text, in order of first appearance.
+func distinct(texts []sourceText) []sourceText {
+	seen := make(map[string]bool)
+	var d []sourceText
+	for _, s := range texts {
+		if !seen[s.text] {
+			seen[s.text] = true
+			d = append(d, s)
+		}
+	}
+	return d
+}
+
+// dedup translates each distinct text once and
+// copies the result to every line with the same text.
+func (t *Translator) dedup(ctx context.Context, texts []sourceText, each func(Result)) ([]Result, error) {
+	lines := make(map[string][]int)
+	for _, s := range texts {
+		lines[s.text] = append(lines[s.text], s.line)
+	}
+	var all []Result
+	_, err := t.run(ctx, distinct(texts), func(r Result) {
+		for _, l := range lines[r.Source] {
+			r.Line = l
+			all = append(all, r)
+			each(r)
+		}
+	})
+	return all, err
+}
+
+// run translates texts through the worker pool,
+// calling each, on the caller's goroutine, as every result arrives.
+func (t *Translator) run(ctx context.Context, texts []sourceText, each func(Result)) ([]Result, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	toTranslate := make(chan sourceText)
+	translated := make(chan Result)
+	var wg sync.WaitGroup
+	t.startWorkers(ctx, toTranslate, translated, &wg)
+	// Once every worker is done, nothing more can arrive.
+	go func() {
+		wg.Wait()
+		close(translated)
+	}()
+	// Enqueue jobs
+	go func() {
+		defer close(toTranslate)
+		for _, s := range texts {
+			select {
+			case toTranslate <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// Collect results as they arrive
+	var results byLine
+	stop := NewStopper(t.FailFast, t.MaxErrors)
+	for r := range translated {
+		if stop.Err() != nil && errors.Is(r.Err, ctx.Err()) {
+			continue
+		}
+		results = append(results, r)
+		if ctx.Err() == nil || !errors.Is(r.Err, ctx.Err()) {
+			each(r)
+		}
+		if ctx.Err() == nil && stop.Observe(r) {
+			cancel()
+		}
+	}
+	if err := parent.Err(); err != nil {
+		return finished(results, err), err
+	}
+	return results, stop.Err()
+}
+
+// ErrTooManyErrors is the error from Translate and TranslateAll
+// stopping at MaxErrors failed lines.
+var ErrTooManyErrors = errors.New("translate: too many lines failed")
+
+// finished drops the results that failed only because of
+// the cancellation err.
+func finished(results []Result, err error) []Result {
+	var done []Result
+	for _, r := range results {
+		if !errors.Is(r.Err, err) {
+			done = append(done, r)
+		}
+	}
+	return done
+}
@@ -0,0 +1,1058 @@
+package translate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// stub is a fake v2 API. It lists zh-CN, zh-TW and en as its languages,
+// detects text with Latin letters as en and anything else as zh-CN,
+// and translates each text to "T:" and the text, unless respond is set.
+// It records every translate request.
+type stub struct {
+	*httptest.Server
+
+	// respond, if set, answers translate request n, from 0, instead.
+	// r's form is parsed, with the POSTed texts in it.
+	respond func(n int, r *http.Request) (status int, body string)
+
+	mu          sync.Mutex
+	requests    []*http.Request
+	times       []time.Time
+	inFlight    int
+	maxInFlight int
+	conns       int
+}
+
+// newStub starts a stub and returns it with a Translator that uses it,
+// with no retries and no real rate limit.
+func newStub(t testing.TB, respond func(n int, r *http.Request) (int, string)) (*stub, *Translator) {
+	s := &stub{respond: respond}
+	s.Server = httptest.NewUnstartedServer(s)
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	tr := New("KEY")
+	tr.BaseURL = s.URL
+	tr.Client = s.Client()
+	tr.Rate = 1e6
+	tr.Retries = 0
+	return s, tr
+}
+
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = zr
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/languages"):
+		io.WriteString(w, `{"data": {"languages": [{"language": "zh-CN"}, {"language": "zh-TW"}, {"language": "en"}]}}`)
+		return
+	case strings.HasSuffix(r.URL.Path, "/detect"):
+		var ds [][]map[string]string
+		for _, q := range r.Form["q"] {
+			ds = append(ds, []map[string]string{{"language": detected(q)}})
+		}
+		writeJSON(w, map[string]interface{}{"data": map[string]interface{}{"detections": ds}})
+		return
+	}
+	s.mu.Lock()
+	n := len(s.requests)
+	s.requests = append(s.requests, r)
+	s.times = append(s.times, time.Now())
+	if s.inFlight++; s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+	status, body := http.StatusOK, prefixed(r)
+	if s.respond != nil {
+		status, body = s.respond(n, r)
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// sent returns every text the stub was asked to translate, sorted.
+func (s *stub) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var texts []string
+	for _, r := range s.requests {
+		texts = append(texts, r.Form["q"]...)
+	}
+	sort.Strings(texts)
+	return texts
+}
+
+// count returns how many translate requests the stub got.
+func (s *stub) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+// detected is the language the stub detects text as.
+func detected(text string) string {
+	if regexp.MustCompile(`[A-Za-z]`).MatchString(text) {
+		return "en"
+	}
+	return "zh-CN"
+}
+
+// prefixed is the stub's usual translation of r's texts.
+func prefixed(r *http.Request) string {
+	var texts []string
+	for _, q := range r.Form["q"] {
+		texts = append(texts, "T:"+q)
+	}
+	if r.Form.Get("source") == "" {
+		return translationsFrom(texts, "zh-CN")
+	}
+	return translations(texts...)
+}
+
+// translations returns a v2 response with texts as the translations.
+func translations(texts ...string) string {
+	return translationsFrom(texts, "")
+}
+
+// translationsFrom is translations, saying each was detected as lang if it isn't "".
+func translationsFrom(texts []string, lang string) string {
+	tts := []map[string]string{}
+	for _, text := range texts {
+		tt := map[string]string{"translatedText": text}
+		if lang != "" {
+			tt["detectedSourceLanguage"] = lang
+		}
+		tts = append(tts, tt)
+	}
+	b, _ := json.Marshal(map[string]interface{}{"data": map[string]interface{}{"translations": tts}})
+	return string(b)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, _ := json.Marshal(v)
+	w.Write(b)
+}
+
+// resultTexts returns the translated text of each result.
+func resultTexts(results []Result) []string {
+	var texts []string
+	for _, r := range results {
+		texts = append(texts, r.Text)
+	}
+	return texts
+}
+
+// failing answers translate requests with status and an error body.
+func failing(status int, reason string) func(int, *http.Request) (int, string) {
+	return func(int, *http.Request) (int, string) {
+		return status, fmt.Sprintf(`{"error": {"code": %d, "message": "failed with %d", "errors": [{"reason": %q}]}}`, status, status, reason)
+	}
+}
+
+// failingOn is failing for requests with a text containing substr,
+// and the usual translation for the rest.
+func failingOn(substr string, status int) func(int, *http.Request) (int, string) {
+	return func(n int, r *http.Request) (int, string) {
+		for _, q := range r.Form["q"] {
+			if strings.Contains(q, substr) {
+				return failing(status, "")(n, r)
+			}
+		}
+		return http.StatusOK, prefixed(r)
+	}
+}
+
+func TestTranslateAll(t *testing.T) {
+	var many, manyWant []string
+	for i := 0; i < 120; i++ {
+		many = append(many, fmt.Sprint("行", i))
+		manyWant = append(manyWant, fmt.Sprint("T:行", i))
+	}
+	tests := []struct {
+		name  string
+		set   func(*Translator)
+		lines []string
+		want  []string
+		sent  []string // Sorted; nil to not check.
+	}{
+		{
+			name:  "in order across batches",
+			set:   func(t *Translator) { t.BatchSize = 7 },
+			lines: many,
+			want:  manyWant,
+		},
+		{
+			name:  "blank lines kept in place and not sent",
+			lines: []string{"一", "", "  ", "二"},
+			want:  []string{"T:一", "", "", "T:二"},
+			sent:  []string{"一", "二"},
+		},
+		{
+			name:  "skip untranslatable",
+			set:   func(t *Translator) { t.SkipUntranslatable = true },
+			lines: []string{"简体", "繁體", "abc"},
+			want:  []string{"T:简体", "繁體", "abc"},
+			sent:  []string{"简体"},
+		},
+		{
+			name:  "comments passed through",
+			set:   func(t *Translator) { t.CommentPrefix = "#" },
+			lines: []string{"# 注释", "一", "  #二"},
+			want:  []string{"# 注释", "T:一", "  #二"},
+			sent:  []string{"一"},
+		},
+		{
+			name:  "dedup sends each distinct line once",
+			set:   func(t *Translator) { t.Dedup = true },
+			lines: []string{"一", "二", "一", "一", "二"},
+			want:  []string{"T:一", "T:二", "T:一", "T:一", "T:二"},
+			sent:  []string{"一", "二"},
+		},
+		{
+			name:  "glossary",
+			set:   func(t *Translator) { t.Glossary = map[string]string{"软件": "軟體", "软": "X"} },
+			lines: []string{"软件很软"},
+			want:  []string{"T:軟體很X"},
+			sent:  []string{"軟體很X"},
+		},
+		{
+			name:  "trim",
+			set:   func(t *Translator) { t.Trim = true },
+			lines: []string{"  一 ", "\t二"},
+			want:  []string{"  T:一 ", "\tT:二"},
+			sent:  []string{"一", "二"},
+		},
+		{
+			name:  "normalize",
+			set:   func(t *Translator) { t.Normalize = true },
+			lines: []string{"e\u0301"},
+			want:  []string{"T:\u00e9"},
+			sent:  []string{"\u00e9"},
+		},
+		{
+			name:  "protect",
+			set:   func(t *Translator) { t.Protect = regexp.MustCompile(`\{\w+\}`) },
+			lines: []string{"Hello {name}"},
+			want:  []string{"T:Hello {name}"},
+			sent:  []string{"Hello \uE000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, nil)
+			if tt.set != nil {
+				tt.set(tr)
+			}
+			results, err := tr.TranslateAll(context.Background(), tt.lines)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, r := range results {
+				if r.Line != i || r.Source != tt.lines[i] && !tr.Normalize || r.Err != nil {
+					t.Errorf("result %d = %+v", i, r)
+				}
+			}
+			if got := resultTexts(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.sent != nil {
+				if got := s.sent(); !reflect.DeepEqual(got, tt.sent) {
+					t.Errorf("sent %q, want %q", got, tt.sent)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	_, tr := newStub(t, failingOn("坏", http.StatusBadRequest))
+	out, err := tr.Translate(context.Background(), []string{"一", "坏", "三"})
+	if want := []string{"T:一", "", "T:三"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Line != 1 {
+		t.Fatalf("got error %v, want an Errors for line 2", err)
+	}
+	if !strings.Contains(err.Error(), "line 2: API error 400") {
+		t.Errorf("got error %q", err)
+	}
+}
+
+func TestBatchTranslations(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []string // What the API sends back for 3 texts.
+		want []string
+		errs []bool
+		warn bool
+	}{
+		{"all", []string{"a", "b", "c"}, []string{"a", "b", "c"}, []bool{false, false, false}, false},
+		{"too few", []string{"a", "b"}, []string{"a", "b", ""}, []bool{false, false, true}, false},
+		{"too many", []string{"a", "b", "c", "d"}, []string{"a", "b", "c"}, []bool{false, false, false}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, tr := newStub(t, func(int, *http.Request) (int, string) { return http.StatusOK, translations(tt.got...) })
+			var warnings bytes.Buffer
+			tr.Warnings = log.New(&warnings, "", 0)
+			b := []sourceText{{0, "一"}, {1, "二"}, {2, "三"}}
+			rs := tr.translateFrom(context.Background(), b, tr.Source, tr.newLimiter())
+			if warned := strings.Contains(warnings.String(), "got 4 translations for a batch of 3"); warned != tt.warn {
+				t.Errorf("warned %q", warnings.String())
+			}
+			if got := resultTexts(rs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			for i, r := range rs {
+				if (r.Err != nil) != tt.errs[i] {
+					t.Errorf("line %d: error %v", i+1, r.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*Translator)
+		want url.Values // Each must be there; an empty value must not be.
+	}{
+		{"defaults", nil, url.Values{"source": {"zh-CN"}, "target": {"zh-TW"}, "format": {"text"}, "key": {"KEY"}}},
+		{"custom pair", func(t *Translator) { t.Source, t.Target = "en", "ja" }, url.Values{"source": {"en"}, "target": {"ja"}}},
+		{"html", func(t *Translator) { t.Format = "html" }, url.Values{"format": {"html"}}},
+		{"params", func(t *Translator) { t.Params = url.Values{"model": {"nmt"}} }, url.Values{"model": {"nmt"}}},
+		{"escaped", func(t *Translator) { t.Target = "a&b=c" }, url.Values{"target": {"a&b=c"}}},
+		{"detect inline", func(t *Translator) { t.DetectInline = true }, url.Values{"source": {""}, "target": {"zh-TW"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, nil)
+			if tt.set != nil {
+				tt.set(tr)
+			}
+			if _, err := tr.Translate(context.Background(), []string{"你好 & 再见"}); err != nil {
+				t.Fatal(err)
+			}
+			form := s.requests[0].Form
+			if got := form["q"]; !reflect.DeepEqual(got, []string{"你好 & 再见"}) {
+				t.Errorf("q = %q", got)
+			}
+			for k, vs := range tt.want {
+				if vs[0] == "" {
+					if _, ok := form[k]; ok {
+						t.Errorf("%s = %q, want it left out", k, form[k])
+					}
+				} else if !reflect.DeepEqual(form[k], vs) {
+					t.Errorf("%s = %q, want %q", k, form[k], vs)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectInline(t *testing.T) {
+	_, tr := newStub(t, nil)
+	tr.DetectInline = true
+	results, err := tr.TranslateAll(context.Background(), []string{"你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.Language != "zh-CN" || r.Text != "T:你好" {
+		t.Errorf("got %+v, want zh-CN detected", r)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper from a func.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestTransportError(t *testing.T) {
+	tr := New("SECRETKEY")
+	tr.Retries = 0
+	tr.Client = &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("no route to host")
+	})}
+	results, err := tr.TranslateAll(context.Background(), []string{"一", "二"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err == nil || !strings.Contains(r.Err.Error(), "no route to host") {
+			t.Errorf("line %d: got error %v, want the transport's", r.Line+1, r.Err)
+		}
+		if strings.Contains(r.Err.Error(), "SECRETKEY") {
+			t.Errorf("line %d: the error %q has the key in it", r.Line+1, r.Err)
+		}
+		if r.Status != 0 {
+			t.Errorf("line %d: status %d without a response", r.Line+1, r.Status)
+		}
+	}
+	if s := tr.Stats(); s.Failed != 1 && s.Failed != 2 {
+		t.Errorf("Stats().Failed = %d, want a request per batch", s.Failed)
+	}
+}
+
+func TestBadResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"no translations", http.StatusOK, `{"data": {"translations": []}}`, "no translations in response"},
+		{"error-shaped 200", http.StatusOK, `{"error": {"code": 500, "message": "oops"}}`, "no translations in response"},
+		{"HTML page", http.StatusOK, "<html><body>Proxy login</body></html>", "<html><body>Proxy login"},
+		{"HTML error page", http.StatusBadGateway, "<html><body>Bad Gateway</body></html>", "API error 502: <html><body>Bad Gateway"},
+		{"API error", http.StatusBadRequest, `{"error": {"code": 400, "message": "Invalid Value"}}`, "API error 400: Invalid Value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, tr := newStub(t, func(int, *http.Request) (int, string) { return tt.status, tt.body })
+			results, err := tr.TranslateAll(context.Background(), []string{"一"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := results[0].Err; err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one with %q", err, tt.wantErr)
+			}
+			if results[0].Status != tt.status {
+				t.Errorf("status %d, want %d", results[0].Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		reason    string
+		quota     bool
+		retryable bool
+	}{
+		{"daily limit", http.StatusForbidden, "dailyLimitExceeded", true, false},
+		{"user rate limit", http.StatusForbidden, "userRateLimitExceeded", true, false},
+		{"forbidden", http.StatusForbidden, "forbidden", false, false},
+		{"bad request", http.StatusBadRequest, "invalid", false, false},
+		{"too many requests", http.StatusTooManyRequests, "rateLimitExceeded", false, true},
+		{"unavailable", http.StatusServiceUnavailable, "backendError", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, tr := newStub(t, failing(tt.status, tt.reason))
+			results, _ := tr.TranslateAll(context.Background(), []string{"一"})
+			err := results[0].Err
+			if want := fmt.Sprintf("API error %d: failed with %d", tt.status, tt.status); err == nil || !strings.HasSuffix(err.Error(), want) {
+				t.Fatalf("got error %v, want it to end %q", err, want)
+			}
+			if errors.Is(err, ErrQuotaExceeded) != tt.quota {
+				t.Errorf("errors.Is(err, ErrQuotaExceeded) = %v", !tt.quota)
+			}
+			var ae *apiError
+			if !errors.As(err, &ae) || retryable(ae) != tt.retryable {
+				t.Errorf("retryable(err) = %v", !tt.retryable)
+			}
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	_, tr := newStub(t, func(_ int, r *http.Request) (int, string) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+		return http.StatusOK, prefixed(r)
+	})
+	tr.Client.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	results, err := tr.TranslateAll(context.Background(), []string{"一"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ne interface{ Timeout() bool }
+	if !errors.As(results[0].Err, &ne) || !ne.Timeout() {
+		t.Errorf("got error %v, want a timeout", results[0].Err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("took %v to time out", d)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	_, tr := newStub(t, func(_ int, r *http.Request) (int, string) {
+		if strings.Contains(r.Form.Get("q"), "慢") {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		return http.StatusOK, prefixed(r)
+	})
+	tr.RequestTimeout = 100 * time.Millisecond
+	tr.BatchSize = 1
+	results, err := tr.TranslateAll(context.Background(), []string{"一", "慢", "三"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if failed := r.Err != nil; failed != (i == 1) {
+			t.Errorf("line %d: error %v", i+1, r.Err)
+		}
+	}
+	if err := results[1].Err; err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	slow := make(chan struct{})
+	_, tr := newStub(t, func(_ int, r *http.Request) (int, string) {
+		if r.Form.Get("q") == "慢" {
+			close(slow)
+			<-r.Context().Done()
+		}
+		return http.StatusOK, prefixed(r)
+	})
+	tr.Concurrency, tr.BatchSize = 1, 1
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-slow
+		cancel()
+	}()
+	start := time.Now()
+	results, err := tr.TranslateAll(ctx, []string{"一", "二", "慢", "四"})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("took %v to stop", d)
+	}
+	if got, want := resultTexts(results), []string{"T:一", "T:二"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want the lines done before cancelling, %q", got, want)
+	}
+}
+
+func TestPost(t *testing.T) {
+	s, tr := newStub(t, nil)
+	tr.Concurrency = 1
+	long := strings.Repeat("长", maxGetQuery)
+	out, err := tr.Translate(context.Background(), []string{"短", long})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[1] != "T:"+long {
+		t.Errorf("the long line came back as %d bytes", len(out[1]))
+	}
+	for _, r := range s.requests {
+		long := len(r.Form.Get("q")) > maxGetQuery || len(r.Form["q"]) > 1
+		if want := map[bool]string{false: "GET", true: "POST"}[long]; r.Method != want {
+			t.Errorf("%d bytes of text sent with %s, want %s", len(strings.Join(r.Form["q"], "")), r.Method, want)
+		}
+		if r.Method == "POST" && strings.Contains(r.URL.RawQuery, "q=") {
+			t.Errorf("POST has the text in its URL")
+		}
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		name                     string
+		concurrency, maxInFlight int
+		want                     int
+	}{
+		{"workers", 3, 0, 3},
+		{"max in flight", 8, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, func(_ int, r *http.Request) (int, string) {
+				time.Sleep(20 * time.Millisecond)
+				return http.StatusOK, prefixed(r)
+			})
+			tr.Concurrency, tr.MaxInFlight, tr.BatchSize = tt.concurrency, tt.maxInFlight, 1
+			lines := make([]string, 30)
+			for i := range lines {
+				lines[i] = fmt.Sprint("行", i)
+			}
+			if _, err := tr.Translate(context.Background(), lines); err != nil {
+				t.Fatal(err)
+			}
+			if s.maxInFlight != tt.want {
+				t.Errorf("%d requests at once, want %d", s.maxInFlight, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedClient(t *testing.T) {
+	s, tr := newStub(t, nil)
+	var mu sync.Mutex
+	const workers = 4
+	tr.Client = NewClient(workers, time.Second)
+	used := 0
+	base := tr.Client.Transport
+	tr.Client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		used++
+		mu.Unlock()
+		return base.RoundTrip(req)
+	})
+	tr.Concurrency, tr.BatchSize = workers, 1
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = fmt.Sprint("行", i)
+	}
+	if _, err := tr.Translate(context.Background(), lines); err != nil {
+		t.Fatal(err)
+	}
+	if used != s.count() {
+		t.Errorf("%d requests went through the Client, of %d", used, s.count())
+	}
+	if s.conns > workers {
+		t.Errorf("%d connections for %d workers, want them kept alive and shared", s.conns, workers)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	s, tr := newStub(t, failingOn("坏", http.StatusBadRequest))
+	tr.FailFast = true
+	tr.Concurrency, tr.BatchSize = 1, 1
+	lines := []string{"一", "坏", "三", "四", "五", "六", "七", "八"}
+	_, err := tr.TranslateAll(context.Background(), lines)
+	var le *LineError
+	if !errors.As(err, &le) || le.Line != 1 {
+		t.Fatalf("got error %v, want a *LineError for line 2", err)
+	}
+	if n := s.count(); n > 3 {
+		t.Errorf("%d requests, want it to stop after line 2", n)
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	const max = 3
+	lines := []string{"坏1", "坏2", "坏3", "坏4", "五", "六", "七", "八", "九", "十"}
+	tests := []struct {
+		name string
+		run  func(*Translator) ([]Result, error)
+	}{
+		{"TranslateAll", func(t *Translator) ([]Result, error) { return t.TranslateAll(context.Background(), lines) }},
+		{"TranslateStream", func(t *Translator) ([]Result, error) { return stream(t, lines), nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, failingOn("坏", http.StatusBadRequest))
+			tr.MaxErrors = max
+			tr.Concurrency, tr.BatchSize = 1, 1
+			results, err := tt.run(tr)
+			if tt.name == "TranslateAll" && err != ErrTooManyErrors {
+				t.Errorf("got error %v, want ErrTooManyErrors", err)
+			}
+			failed := 0
+			for _, r := range results {
+				if r.Err != nil {
+					failed++
+				}
+			}
+			if failed < max || failed > max+1 {
+				t.Errorf("%d lines failed, want it to stop at %d", failed, max)
+			}
+			if n := s.count(); n > max+1 {
+				t.Errorf("%d requests, want it to stop after %d", n, max)
+			}
+		})
+	}
+}
+
+func TestQuota(t *testing.T) {
+	lines := make([]string, 30)
+	for i := range lines {
+		lines[i] = fmt.Sprint("行", i)
+	}
+	tests := []struct {
+		name string
+		run  func(*Translator) ([]Result, error)
+	}{
+		{"TranslateAll", func(t *Translator) ([]Result, error) { return t.TranslateAll(context.Background(), lines) }},
+		{"TranslateStream", func(t *Translator) ([]Result, error) { return stream(t, lines), nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tr := newStub(t, failing(http.StatusForbidden, "dailyLimitExceeded"))
+			tr.Concurrency, tr.BatchSize = 2, 1
+			results, err := tt.run(tr)
+			if tt.name == "TranslateAll" && !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("got error %v, want ErrQuotaExceeded", err)
+			}
+			if len(results) == 0 || !errors.Is(results[0].Err, ErrQuotaExceeded) {
+				t.Errorf("got %+v, want the quota error first", results)
+			}
+			if n := s.count(); n > 2*tr.Concurrency {
+				t.Errorf("%d requests, want it to stop at the first quota error", n)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	s, tr := newStub(t, nil)
+	tr.Detect = true
+	tr.Concurrency = 1
+	results, err := tr.TranslateAll(context.Background(), []string{"hello", "你好", "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"en", "zh-CN", "en"} {
+		if results[i].Language != want || results[i].Err != nil {
+			t.Errorf("line %d: got %+v, want it detected as %s", i+1, results[i], want)
+		}
+	}
+	sources := map[string]string{}
+	for _, r := range s.requests {
+		for _, q := range r.Form["q"] {
+			sources[q] = r.Form.Get("source")
+		}
+	}
+	if want := map[string]string{"hello": "en", "world": "en", "你好": "zh-CN"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("sent from %v, want %v", sources, want)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*Translator)
+		want string
+	}{
+		{"text", nil, `'test"`},
+		{"no unescape", func(t *Translator) { t.NoUnescape = true }, "&#39;test&quot;"},
+		{"html", func(t *Translator) { t.Format = "html" }, "&#39;test&quot;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, tr := newStub(t, func(int, *http.Request) (int, string) {
+				return http.StatusOK, translations("&#39;test&quot;")
+			})
+			if tt.set != nil {
+				tt.set(tr)
+			}
+			out, err := tr.Translate(context.Background(), []string{"测试"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out[0] != tt.want {
+				t.Errorf("got %q, want %q", out[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLSurvives(t *testing.T) {
+	s, tr := newStub(t, nil)
+	tr.Format = "html"
+	out, err := tr.Translate(context.Background(), []string{"<b>你好</b> &amp; 世界"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "T:<b>你好</b> &amp; 世界"; out[0] != want {
+		t.Errorf("got %q, want %q", out[0], want)
+	}
+	if got := s.requests[0].Form.Get("format"); got != "html" {
+		t.Errorf("format = %q, want html", got)
+	}
+}
+
+func TestOnResult(t *testing.T) {
+	_, tr := newStub(t, nil)
+	tr.SkipUntranslatable = true
+	var mu sync.Mutex
+	calls := map[int]int{}
+	tr.OnResult = func(r Result) {
+		mu.Lock()
+		calls[r.Line]++
+		mu.Unlock()
+	}
+	lines := []string{"一", "", "abc", "四", "五"}
+	if _, err := tr.TranslateAll(context.Background(), lines); err != nil {
+		t.Fatal(err)
+	}
+	for i := range lines {
+		if calls[i] != 1 {
+			t.Errorf("line %d: called %d times, want once", i+1, calls[i])
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	_, tr := newStub(t, nil)
+	var mu sync.Mutex
+	var last [2]int
+	tr.Progress = func(done, total int) {
+		mu.Lock()
+		last = [2]int{done, total}
+		mu.Unlock()
+	}
+	if _, err := tr.TranslateAll(context.Background(), []string{"一", "", "三"}); err != nil {
+		t.Fatal(err)
+	}
+	if last != [2]int{3, 3} {
+		t.Errorf("last progress %v, want 3 of 3", last)
+	}
+}
+
+func TestLargeInput(t *testing.T) {
+	_, tr := newStub(t, nil)
+	tr.BatchSize = 10
+	lines := make([]string, 5000)
+	for i := range lines {
+		lines[i] = fmt.Sprint("行", i)
+	}
+	done := make(chan []Result)
+	go func() {
+		results, _ := tr.TranslateAll(context.Background(), lines)
+		done <- results
+	}()
+	select {
+	case results := <-done:
+		if len(results) != len(lines) {
+			t.Fatalf("got %d results for %d lines", len(results), len(lines))
+		}
+		for i, r := range results {
+			if r.Line != i || r.Text != "T:"+lines[i] {
+				t.Fatalf("result %d = %+v", i, r)
+			}
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("deadlocked")
+	}
+}
+
+// prefixer is a Provider that prefixes texts with "P:" and its language pair.
+type prefixer struct{ calls int32 }
+
+func (p *prefixer) Translate(_ context.Context, texts []string, source, target string) ([]string, error) {
+	atomic.AddInt32(&p.calls, 1)
+	out := make([]string, len(texts))
+	for i, s := range texts {
+		out[i] = "P:" + source + ">" + target + ":" + s
+	}
+	return out, nil
+}
+
+func TestProvider(t *testing.T) {
+	p := &prefixer{}
+	tr := New("")
+	tr.Provider = p
+	out, err := tr.Translate(context.Background(), []string{"一", "二"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"P:zh-CN>zh-TW:一", "P:zh-CN>zh-TW:二"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	if p.calls == 0 {
+		t.Error("the Provider wasn't called")
+	}
+}
+
+func TestGoogleProvider(t *testing.T) {
+	_, tr := newStub(t, nil)
+	tr.Provider = Google{tr}
+	tr.DetectInline = true
+	results, err := tr.TranslateAll(context.Background(), []string{"一"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.Text != "T:一" || r.Status != http.StatusOK || r.Latency <= 0 || r.Language != "zh-CN" {
+		t.Errorf("got %+v, want the status, latency and language kept", r)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, tr := newStub(t, failingOn("坏", http.StatusBadRequest))
+	tr.BatchSize = 1
+	if _, err := tr.TranslateAll(context.Background(), []string{"一", "坏", "三", "四"}); err != nil {
+		t.Fatal(err)
+	}
+	s := tr.Stats()
+	if s.Requests != 4 || s.Statuses[200] != 3 || s.Statuses[400] != 1 || s.Retries != 0 || s.Failed != 0 {
+		t.Errorf("got %+v, want 4 requests: 3 200s and a 400", s)
+	}
+	if s.BytesSent == 0 || s.BytesReceived == 0 {
+		t.Errorf("got %+v, want bytes counted", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(*Translator)
+		wantErr string
+	}{
+		{"ok", func(*Translator) {}, ""},
+		{"no key", func(t *Translator) { t.APIKey = "" }, "no API key"},
+		{"provider needs no key", func(t *Translator) { t.APIKey, t.Provider = "", &prefixer{} }, ""},
+		{"bad version", func(t *Translator) { t.APIVersion = 4 }, "API version"},
+		{"v3 without project", func(t *Translator) { t.APIVersion = 3 }, "needs a project"},
+		{"v3 detect", func(t *Translator) { t.APIVersion, t.Project, t.Detect = 3, "p", true }, "needs the v2 API"},
+		{"v3 params", func(t *Translator) { t.APIVersion, t.Project, t.Params = 3, "p", url.Values{"model": {"nmt"}} }, "only for the v2 API"},
+		{"reserved param", func(t *Translator) { t.Params = url.Values{"key": {"x"}} }, `can't set "key"`},
+		{"no target", func(t *Translator) { t.Target = "" }, "must not be empty"},
+		{"bad format", func(t *Translator) { t.Format = "xml" }, "text or html"},
+		{"no workers", func(t *Translator) { t.Concurrency = 0 }, "concurrency"},
+		{"no rate", func(t *Translator) { t.Rate = 0 }, "rate must be positive"},
+		{"min rate over rate", func(t *Translator) { t.MinRate = t.Rate + 1 }, "min rate"},
+		{"negative burst", func(t *Translator) { t.Burst = -1 }, "burst must not be negative"},
+		{"negative min interval", func(t *Translator) { t.MinInterval = -1 }, "min interval must not be negative"},
+		{"negative max in flight", func(t *Translator) { t.MaxInFlight = -1 }, "max in flight must not be negative"},
+		{"negative max chars", func(t *Translator) { t.MaxChars = -1 }, "max chars"},
+		{"tiny max bytes", func(t *Translator) { t.MaxBytes = 2 }, "max bytes"},
+		{"no batch", func(t *Translator) { t.BatchSize = 0 }, "batch size"},
+		{"both detects", func(t *Translator) { t.Detect, t.DetectInline = true, true }, "not both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := New("KEY")
+			tt.set(tr)
+			_, err := tr.Translate(context.Background(), nil)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// stream translates lines with TranslateStream and returns the results by line.
+func stream(t *Translator, lines []string) []Result {
+	in := make(chan string)
+	out, err := t.TranslateStream(context.Background(), in)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		defer close(in)
+		for _, l := range lines {
+			select {
+			case in <- l:
+			case <-time.After(time.Second):
+				return // It stopped taking them.
+			}
+		}
+	}()
+	var results []Result
+	for r := range out {
+		results = append(results, r)
+	}
+	sort.Sort(byLine(results))
+	return results
+}
+
+// benchLines returns n short lines to translate.
+func benchLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprint("第", i, "行")
+	}
+	return lines
+}
+
+// BenchmarkTranslate translates 1000 lines against a stub that takes
+// a millisecond a request, at a range of concurrency levels.
+func BenchmarkTranslate(b *testing.B) {
+	lines := benchLines(1000)
+	for _, n := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprint("concurrency=", n), func(b *testing.B) {
+			_, tr := newStub(b, func(_ int, r *http.Request) (int, string) {
+				time.Sleep(time.Millisecond)
+				return http.StatusOK, prefixed(r)
+			})
+			tr.Concurrency, tr.BatchSize = n, 10
+			tr.Client = NewClient(n, DefaultTimeout)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := tr.Translate(context.Background(), lines); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(lines)*b.N)/b.Elapsed().Seconds(), "lines/s")
+		})
+	}
+}
+
+// BenchmarkClient translates 1000 lines a request at a time through
+// NewClient's pooled connections, and through a client that opens
+// a connection for every request, to show what the pool saves.
+func BenchmarkClient(b *testing.B) {
+	lines := benchLines(1000)
+	const workers = 20
+	clients := []struct {
+		name   string
+		client func() *http.Client
+	}{
+		{"pooled", func() *http.Client { return NewClient(workers, DefaultTimeout) }},
+		{"no keep-alives", func() *http.Client {
+			return &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: DefaultTimeout}
+		}},
+	}
+	for _, c := range clients {
+		b.Run(c.name, func(b *testing.B) {
+			s, tr := newStub(b, nil)
+			tr.Concurrency, tr.BatchSize = workers, 1
+			tr.Client = c.client()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := tr.Translate(context.Background(), lines); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			s.mu.Lock()
+			b.ReportMetric(float64(s.conns)/float64(b.N), "conns/op")
+			s.mu.Unlock()
+		})
+	}
+}
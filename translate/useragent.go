@@ -0,0 +1,21 @@
+package translate
+
+import "runtime/debug"
+
+const modulePath = "github.com/purohit/simp-to-trad-goog-api"
+
+// DefaultUserAgent identifies requests as this package's,
+// with its version when the build knows it.
+var DefaultUserAgent = userAgent()
+
+func userAgent() string {
+	ua := "simp-to-trad-goog-api"
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range append([]*debug.Module{&bi.Main}, bi.Deps...) {
+			if m.Path == modulePath && m.Version != "" && m.Version != "(devel)" {
+				return ua + "/" + m.Version
+			}
+		}
+	}
+	return ua
+}
@@ -0,0 +1,138 @@
+package translate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// The v3 API takes JSON and authenticates with OAuth2 rather than a key.
+const (
+	DefaultV3BaseURL = "https://translation.googleapis.com/v3"
+	v3Scope          = "https://www.googleapis.com/auth/cloud-translation"
+)
+
+// Requests go to projects/{Project}:translateText as:
+//{"contents": ["你觉得紧张吗？"], "sourceLanguageCode": "zh-CN", "targetLanguageCode": "zh-TW", "mimeType": "text/plain"}
+
+type v3RequestJSON struct {
+	Contents           []string `json:"contents"`
+	SourceLanguageCode string   `json:"sourceLanguageCode,omitempty"`
+	TargetLanguageCode string   `json:"targetLanguageCode"`
+	MimeType           string   `json:"mimeType,omitempty"`
+}
+
+// and come back as:
+//{"translations": [{"translatedText": "你覺得緊張嗎？"}]}
+
+type v3RespJSON struct {
+	Translations []struct {
+		Text     string `json:"translatedText"`
+		Detected string `json:"detectedLanguageCode"` // When sourceLanguageCode was left out.
+	} `json:"translations"`
+}
+
+// Supported languages come from projects/{Project}/supportedLanguages:
+//{"languages": [{"languageCode": "zh-TW", ...}, ...]}
+
+type v3LangsJSON struct {
+	Languages []struct {
+		Code string `json:"languageCode"`
+	} `json:"languages"`
+}
+
+// NewServiceAccountClient returns a client like NewClient's that
+// authenticates to the v3 API with the service-account JSON key creds,
+// and the project the key belongs to.
+func NewServiceAccountClient(ctx context.Context, creds []byte, maxConns int, timeout time.Duration) (*http.Client, string, error) {
+	c, err := google.CredentialsFromJSON(ctx, creds, v3Scope)
+	if err != nil {
+		return nil, "", err
+	}
+	client := NewClient(maxConns, timeout)
+	client.Transport = &oauth2.Transport{Source: c.TokenSource, Base: client.Transport}
+	return client, c.ProjectID, nil
+}
+
+// v3Query returns the request body to translate texts from source to target.
+func (t *Translator) v3Query(texts []string, source, target string) interface{} {
+	mime := "text/plain"
+	if t.Format == "html" {
+		mime = "text/html"
+	}
+	return v3RequestJSON{Contents: texts, SourceLanguageCode: source, TargetLanguageCode: target, MimeType: mime}
+}
+
+// newV3Request builds a request to method of Project, POSTing body
+// as JSON, or a GET if body is nil. Cancelling ctx aborts the request.
+func (t *Translator) newV3Request(ctx context.Context, method string, body interface{}) (*http.Request, error) {
+	endpoint := t.baseURL() + "/projects/" + t.Project + method
+	if body == nil {
+		return http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// requestV3 translates texts from source to target in a single v3 API call.
+// Unlike v2, plain text comes back without HTML entities.
+func (t *Translator) requestV3(ctx context.Context, texts []string, source, target string, rep *reply) ([]string, error) {
+	req, err := t.newV3Request(ctx, ":translateText", t.v3Query(texts, source, target))
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.send(req, len(texts), rep)
+	if err != nil {
+		return nil, err
+	}
+	var j v3RespJSON
+	if err := decode(body, &j); err != nil {
+		return nil, err
+	}
+	if len(j.Translations) == 0 {
+		return nil, fmt.Errorf("no translations in response: %q", snippet(body))
+	}
+	translations := make([]string, len(j.Translations))
+	for i, tt := range j.Translations {
+		translations[i] = tt.Text
+		if rep != nil {
+			rep.detected = append(rep.detected, tt.Detected)
+		}
+	}
+	return translations, nil
+}
+
+// languagesV3 returns the language codes the v3 API supports.
+func (t *Translator) languagesV3(ctx context.Context) ([]string, error) {
+	req, err := t.newV3Request(ctx, "/supportedLanguages", nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.send(req, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	var j v3LangsJSON
+	if err := decode(body, &j); err != nil {
+		return nil, err
+	}
+	codes := make([]string, len(j.Languages))
+	for i, l := range j.Languages {
+		codes[i] = l.Code
+	}
+	return codes, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+	"golang.org/x/net/context"
+)
+
+// runUnordered is the rest of run for -unordered: it reads lines from in
+// as the workers take them and writes each translation as soon as it's
+// done, so only the lines in flight and the failures are held in memory.
+// With -stream it writes them in order instead, each as soon as it and
+// the lines before it are done, so it suits input that never ends.
+// Every record ends with a newline (or NUL), whatever the input's last one did.
+func runUnordered(ctx context.Context, t *translate.Translator, in io.Reader, o *options, stdout, stderr io.Writer, logger *log.Logger, start time.Time) int {
+	lines := make(chan string)
+	results, err := t.TranslateStream(ctx, lines)
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+	// So the reading stops if the stream does first, out of quota.
+	feed, stop := context.WithCancel(ctx)
+	defer stop()
+	var rerr error // Safe to read once results is closed, unless ctx was cancelled.
+	go func() {
+		defer close(lines)
+		n := 0
+		_, rerr = scanLines(in, o, func(l string) {
+			if o.warnMixed {
+				warnMixed(stderr, n, l, o)
+			}
+			n++
+			select {
+			case lines <- l:
+			case <-feed.Done():
+			}
+		})
+	}()
+	n := 0
+	var failed []translate.Result
+	// The stream stops itself; this tells why.
+	stopper := translate.NewStopper(false, t.MaxErrors)
+	err = writeOutput(o.out, stdout, func(w io.Writer) error {
+		var werr error
+		write := func(r translate.Result) {
+			if werr != nil || o.dropComments && t.IsComment(r.Source) || o.changedOnly && (r.Err != nil || r.Text == r.Source) {
+				return
+			}
+			werr = encoded(o.outputCharset, func(w io.Writer) error {
+				return writers[o.format](w, []translate.Result{r}, o)
+			})(w)
+			if werr == nil {
+				// Each line is wanted as soon as it's done.
+				werr = w.(*bufio.Writer).Flush()
+			}
+		}
+		// With -stream, the results waiting for the line number next.
+		waiting := make(map[int]translate.Result)
+		next := 0
+		// Keep receiving after a write error, so the stream can finish.
+		for r := range results {
+			n++
+			if t.Progress != nil {
+				t.Progress(n, -1)
+			}
+			if r.Err != nil {
+				failed = append(failed, r)
+			}
+			stopper.Observe(r)
+			if !o.stream {
+				write(r)
+				continue
+			}
+			for waiting[r.Line] = r; ; next++ {
+				r, ok := waiting[next]
+				if !ok {
+					break
+				}
+				delete(waiting, next)
+				write(r)
+			}
+		}
+		// Lines abandoned on cancelling leave gaps; write the rest anyway.
+		lines := make([]int, 0, len(waiting))
+		for l := range waiting {
+			lines = append(lines, l)
+		}
+		sort.Ints(lines)
+		for _, l := range lines {
+			write(waiting[l])
+		}
+		if t.Progress != nil {
+			t.Progress(n, n)
+		}
+		return werr
+	})
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+	if err := t.Close(); err != nil {
+		logger.Print(err)
+		return 1
+	}
+	if errors.Is(stopper.Err(), translate.ErrQuotaExceeded) {
+		// Just the one message rather than a line for every failure.
+		logger.Printf("API quota exhausted; translated %d lines. Try again once it resets.", n-len(failed))
+		return 1
+	}
+	// In order, whichever finished first.
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Line < failed[j].Line })
+	reportErrors(stderr, failed, o)
+	if o.stats {
+		printStats(stderr, t.Stats(), n-len(failed), len(failed), time.Since(start))
+	}
+	if ctx.Err() != nil {
+		logger.Printf("Interrupted; translated %d lines", n)
+		return 1
+	}
+	if rerr != nil {
+		// Everything before it was translated and written.
+		logger.Print(rerr)
+		return 1
+	}
+	if stopper.Err() == translate.ErrTooManyErrors {
+		logger.Printf("Stopping after %d failed lines (-max-errors); translated %d lines", len(failed), n-len(failed))
+		return 1
+	}
+	if len(failed) > 0 {
+		return 1
+	}
+	return 0
+}
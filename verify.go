@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/purohit/simp-to-trad-goog-api/translate"
+	"golang.org/x/net/context"
+)
+
+// backTranslate translates the successful results back from t.Target
+// to t.Source and returns the back translations, by line. The glossary,
+// meant for the other way, is left out, and so is the progress.
+func backTranslate(ctx context.Context, t *translate.Translator, results []translate.Result) (map[int]string, error) {
+	var lines []string
+	var nums []int
+	for _, r := range results {
+		if r.Err == nil {
+			lines = append(lines, r.Text)
+			nums = append(nums, r.Line)
+		}
+	}
+	t.Source, t.Target = t.Target, t.Source
+	skip, glossary, progress, onResult := t.SkipUntranslatable, t.Glossary, t.Progress, t.OnResult
+	t.SkipUntranslatable = false // The translations have no simplified characters to find.
+	t.Glossary, t.Progress, t.OnResult = nil, nil, nil
+	back, err := t.TranslateAll(ctx, lines)
+	t.Source, t.Target = t.Target, t.Source
+	t.SkipUntranslatable, t.Glossary, t.Progress, t.OnResult = skip, glossary, progress, onResult
+	if le, ok := err.(*translate.LineError); ok {
+		le.Line = nums[le.Line]
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int]string, len(back))
+	for _, b := range back {
+		if b.Err == nil {
+			m[nums[b.Line]] = b.Text
+		}
+	}
+	return m, nil
+}
+
+// roundTrip says how r's back translation, if any, compares with its source.
+func roundTrip(r translate.Result, o *options) string {
+	back, ok := o.back[r.Line]
+	switch {
+	case !ok:
+		return ""
+	case back == r.Source:
+		return "match"
+	}
+	return "differs"
+}